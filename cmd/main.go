@@ -9,17 +9,19 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/api"
 	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/helper"
 	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/settings"
-	models "github.com/System-Analysis-and-Design-2023-SUT/Server/models/queue"
 	logging "github.com/System-Analysis-and-Design-2023-SUT/Server/pkg/logger"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/pkg/wal"
 	"github.com/hashicorp/memberlist"
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/spf13/pflag"
+	"google.golang.org/grpc"
 )
 
 var logger *logging.Logger
@@ -54,21 +56,33 @@ func main() {
 
 	fmt.Printf("I'm %s\n", st.Replica.Hostname)
 	gossopingServer := setupGossopingServers(&st)
-	go func() {
-		runGossopingServer(gossopingServer, st.Global.GossopingPort, "gossoping_server")
-	}()
 
-	helper, err := helper.NewHelper(gossopingServer)
+	helper, err := helper.NewHelper(gossopingServer, st.Global.GossopingPort)
 	if err != nil {
 		logger.FatalS("Could not create helper", "error", err.Error())
 	}
-	q := models.NewQueue()
-	s := models.NewSubscriber()
 
-	internalAPIServer := setupHTTPServer(&st, helper, q, s)
+	// Each replica keeps its own log, so a shared volume does not mix them up.
+	w, err := wal.Open(
+		filepath.Join(st.Storage.Path, st.Replica.Hostname[0]),
+		wal.SyncPolicy(st.Storage.Fsync),
+		st.Storage.FsyncInterval,
+	)
+	if err != nil {
+		logger.FatalS("Could not open write-ahead log", "error", err.Error())
+	}
+
+	internalAPIServer, rpcServer := setupHTTPServer(&st, helper, w)
 	go func() {
 		runHTTPServer(internalAPIServer, st.Global.APIPort, "api_server")
 	}()
+	go func() {
+		runRPCServer(rpcServer, st.Global.GRPCPort, "rpc_server")
+	}()
+	// Raft messages of the other members arrive on the gossoping port.
+	go func() {
+		runGossopingServer(helper, &st, "gossoping_server")
+	}()
 
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, syscall.SIGHUP, syscall.SIGINT)
@@ -82,10 +96,31 @@ func main() {
 		logger.Fatal("Could not shutdown gossoping server gracefully", "error", err.Error())
 	}
 
+	if err := helper.Close(); err != nil {
+		logger.Fatal("Could not close connections to other nodes", "error", err.Error())
+	}
+
 	if err := internalAPIServer.Shutdown(ctx); err != nil {
 		logger.Fatal("Could not shutdown internal api server gracefully", "error", err.Error())
 	}
 
+	// Subscribe streams only end with their clients, so they are cut off
+	// when the deadline passes.
+	stopped := make(chan struct{})
+	go func() {
+		rpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		rpcServer.Stop()
+	}
+
+	if err := w.Close(); err != nil {
+		logger.Fatal("Could not close write-ahead log", "error", err.Error())
+	}
+
 	fmt.Println("Shutting Down server...")
 
 }
@@ -125,9 +160,11 @@ func setupGossopingServers(settings *settings.Settings) *memberlist.Memberlist {
 	rand.Seed(time.Now().UnixNano())
 	randomString := randString(4)
 
+	// The memberlist name changes on every restart, so the previous
+	// incarnation is not mistaken for this one. Raft knows the node by its
+	// hostname, which is kept in the metadata.
 	nodeName := settings.Replica.Hostname[0]
 	config.Name = nodeName + randomString
-	fmt.Println("MY NAME", config.Name)
 
 	list, err := memberlist.Create(config)
 	if err != nil {
@@ -141,7 +178,7 @@ func setupGossopingServers(settings *settings.Settings) *memberlist.Memberlist {
 	}
 	fmt.Println("MY IP", ips)
 
-	list.LocalNode().Meta = []byte(ips[0].String())
+	list.LocalNode().Meta = helper.Meta{ID: nodeName, Address: ips[0].String()}.Encode()
 
 	ip, ipnet, err := net.ParseCIDR(settings.Replica.Subnet)
 	if err != nil {
@@ -168,63 +205,28 @@ func setupGossopingServers(settings *settings.Settings) *memberlist.Memberlist {
 		}
 	}
 
-	for _, m := range list.Members() {
-		fmt.Println(m.Meta)
-		m.Addr = net.ParseIP(string(m.Meta))
-
-		fmt.Println("Addr", m.Addr)
-		fmt.Println("Name", m.Name)
-		fmt.Println("Port", m.Port)
-		fmt.Println("State", m.State)
-		fmt.Println("Address", m.Address())
-		fmt.Println("FullAddress", m.FullAddress())
-		fmt.Println("Mask", m.Addr.DefaultMask())
-		fmt.Println("FullAddressAddr", m.FullAddress().Addr)
-		fmt.Println("FullAddressName")
-	}
-
 	return list
 }
 
-func runGossopingServer(ml *memberlist.Memberlist, port int, serverName string) {
-	logger.Infof("%s Starting listening on port %d.", serverName, port)
+func runGossopingServer(h *helper.Helper, settings *settings.Settings, serverName string) {
+	logger.Infof("%s Starting listening on port %d.", serverName, settings.Global.GossopingPort)
 
-	var address = fmt.Sprintf("%s:%d", ml.LocalNode().Addr.String(), port)
+	var address = fmt.Sprintf("%s:%d", settings.Replica.BindAddress, settings.Global.GossopingPort)
 	l, err := net.Listen("tcp", address)
 	if err != nil {
 		logger.Fatalf("Cannot start the cluster node: %v", err)
 	}
-	defer l.Close()
 
-	for {
-		c, err := l.Accept()
-		if err != nil {
-			continue
-		}
-		handleConnection(c)
-	}
-}
-
-func handleConnection(conn net.Conn) {
-	defer conn.Close()
-
-	// Read data from the connection
-	buffer := make([]byte, 1024)
-	n, err := conn.Read(buffer)
+	err = h.Serve(l)
 	if err != nil {
-		fmt.Println("Error reading:", err)
-		return
+		logger.InfoS("Serving failed", "error", err.Error(), "serverName", serverName)
 	}
-
-	// Process the received data
-	data := buffer[:n]
-	fmt.Printf("Received data: %s\n", data)
 }
 
-func setupHTTPServer(settings *settings.Settings, helper *helper.Helper, q *models.Queue, s *models.Subscriber) *http.Server {
+func setupHTTPServer(settings *settings.Settings, helper *helper.Helper, w *wal.Log) (*http.Server, *grpc.Server) {
 	logger.InfoS("Initializing http server.")
 
-	apiServer, err := api.NewAPIServer(settings, helper, q, s)
+	apiServer, rpcServer, err := api.NewAPIServer(settings, helper, w)
 	if err != nil {
 		logger.FatalS("Could not initialize API Server", "error", err.Error())
 	}
@@ -241,7 +243,7 @@ func setupHTTPServer(settings *settings.Settings, helper *helper.Helper, q *mode
 		MaxHeaderBytes:    settings.Global.MaxHeaderBytes,
 	}
 
-	return APIServer
+	return APIServer, rpcServer
 }
 
 func runHTTPServer(server *http.Server, port int, serverName string) {
@@ -255,3 +257,15 @@ func runHTTPServer(server *http.Server, port int, serverName string) {
 		logger.InfoS("Serving failed", "error", err.Error(), "serverName", serverName)
 	}
 }
+
+func runRPCServer(server *grpc.Server, port int, serverName string) {
+	logger.Infof("%s Starting listening on port %d.", serverName, port)
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		logger.Fatal("could not create "+serverName+" server listener", "error", err.Error())
+	}
+	err = server.Serve(ln)
+	if err != nil {
+		logger.InfoS("Serving failed", "error", err.Error(), "serverName", serverName)
+	}
+}
@@ -3,40 +3,62 @@ package api
 import (
 	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/api/health"
 	queue "github.com/System-Analysis-and-Design-2023-SUT/Server/internal/api/queue"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/api/raft"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/api/rpc"
 	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/api/server"
 	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/helper"
 	queuerepo "github.com/System-Analysis-and-Design-2023-SUT/Server/internal/repository/queue"
 	queueservice "github.com/System-Analysis-and-Design-2023-SUT/Server/internal/services/queue"
 	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/settings"
-	models "github.com/System-Analysis-and-Design-2023-SUT/Server/models/queue"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/pkg/wal"
 	"github.com/pkg/errors"
+	"google.golang.org/grpc"
 )
 
-func NewAPIServer(settings *settings.Settings, helper *helper.Helper, q *models.Queue, s *models.Subscriber) (*server.Server, error) {
-	queueRepo, err := queuerepo.NewRepository(settings, helper, q, s)
+// NewAPIServer builds the HTTP server and the gRPC one, which share the
+// queue repository and service.
+func NewAPIServer(settings *settings.Settings, helper *helper.Helper, w *wal.Log) (*server.Server, *grpc.Server, error) {
+	queueRepo, err := queuerepo.NewRepository(settings, helper, w)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not initialize user repository")
+		return nil, nil, errors.Wrap(err, "could not initialize user repository")
 	}
 
+	// Raft messages of the other members reach the node through the helper.
+	helper.Register(queueRepo.Raft())
+
 	queueService, err := queueservice.NewService(queueRepo)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not initialize user service")
+		return nil, nil, errors.Wrap(err, "could not initialize user service")
 	}
 
 	queueModule, err := queue.NewQueueModule(queueRepo, queueService, settings, helper)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not initialize users module")
+		return nil, nil, errors.Wrap(err, "could not initialize users module")
+	}
+
+	// A joining node is ready once it caught up with the leader.
+	healthModule, err := health.NewHealth(settings.Global.Environment, queueRepo.Raft().Ready)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not initialize health module")
 	}
 
-	healthModule, err := health.NewHealth(settings.Global.Environment)
+	raftModule, err := raft.NewRaftModule(queueRepo.Raft())
 	if err != nil {
-		return nil, errors.Wrap(err, "could not initialize health module")
+		return nil, nil, errors.Wrap(err, "could not initialize raft module")
 	}
 
-	srv, err := server.NewServer(queueModule, healthModule, settings)
+	srv, err := server.NewServer(queueModule, healthModule, raftModule, settings)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not initialize api server object")
+		return nil, nil, errors.Wrap(err, "could not initialize api server object")
 	}
 
-	return srv, nil
+	rpcModule, err := rpc.NewQueueModule(queueRepo, queueService)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not initialize rpc module")
+	}
+
+	rpcServer := grpc.NewServer()
+	rpcModule.Register(rpcServer)
+
+	return srv, rpcServer, nil
 }
@@ -7,6 +7,8 @@ import (
 
 type Health struct {
 	Environment string
+	// ready fails while the node is not ready to serve, nil always passes.
+	ready func() error
 }
 
 // ReadinessRequest Input Model
@@ -35,6 +37,15 @@ func (h *Health) RegisterRoutes(group *gin.RouterGroup) {
 }
 
 func (h *Health) isReady(ctx *gin.Context) {
+	if h.ready != nil {
+		if err := h.ready(); err != nil {
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{
+				"status": "unavailable",
+				"reason": err.Error(),
+			})
+			return
+		}
+	}
 	ctx.JSON(http.StatusOK, gin.H{
 		"status": "ok",
 		"reason": "",
@@ -48,8 +59,10 @@ func (h *Health) isLive(ctx *gin.Context) {
 	})
 }
 
-func NewHealth(env string) (*Health, error) {
+// NewHealth reports the node ready once ready passes.
+func NewHealth(env string, ready func() error) (*Health, error) {
 	return &Health{
 		Environment: env,
+		ready:       ready,
 	}, nil
 }
@@ -1,8 +1,6 @@
 package queue
 
 import (
-	"bytes"
-	"fmt"
 	"log"
 	"net/http"
 
@@ -35,37 +33,166 @@ type Queue struct {
 func (q *Queue) RegisterRoutes(v1 *gin.RouterGroup) {
 	logger.InfoS("Registering queue related endpoints to api server.")
 
-	api := v1.Group("/")
+	// Every queue endpoint is served by the raft leader, the other
+	// replicas route requests to it.
+	v1 = v1.Group("/", q.routeToLeader())
 
-	api.POST("/push", q.pushEndpoint())          // Push into queue.
-	api.POST("/_push", q.pushForceEndpoint())    // Force push into queue.
-	api.GET("/pull", q.pullEndpoint())           // Gets head of queue.
-	api.GET("/_pull", q.pullForceEndpoint())     // Gets head of queue.
-	api.GET("/subscribe", q.subscribeEndpoint()) // Subscribe in queue.
-	api.GET("/queue", q.copyEndpoint())          // Gets whole of queue.
+	// Endpoints on the root serve the default queue.
+	q.registerQueueRoutes(v1.Group("/"))
+	q.registerQueueRoutes(v1.Group("/queues/:name"))
+
+	queues := v1.Group("/queues")
+
+	queues.GET("", q.queuesEndpoint())               // Gets names of queues.
+	queues.POST("/:name", q.createQueueEndpoint())   // Creates a queue.
+	queues.DELETE("/:name", q.deleteQueueEndpoint()) // Deletes a queue.
+
+	admin := v1.Group("/_admin")
+
+	admin.GET("/reconcile", q.reconciliationEndpoint()) // Gets outcome of anti-entropy rounds.
+	admin.POST("/reconcile", q.reconcileEndpoint())     // Reconciles every replica with the leader.
 }
 
-func (q *Queue) pushEndpoint() gin.HandlerFunc {
+func (q *Queue) registerQueueRoutes(api *gin.RouterGroup) {
+	api.POST("/push", q.pushEndpoint())           // Push into queue.
+	api.GET("/pull", q.pullEndpoint())            // Gets head of queue.
+	api.GET("/peek", q.peekEndpoint())            // Gets head of queue without removing it.
+	api.POST("/ack", q.ackEndpoint())             // Acknowledges a leased item.
+	api.GET("/dlq", q.deadLetterEndpoint())       // Gets dead-letter queue.
+	api.POST("/dlq/redrive", q.redriveEndpoint()) // Moves dead-letter items back to queue.
+	api.POST("/dlq/purge", q.purgeEndpoint())     // Drops dead-letter items.
+	api.GET("/subscribe", q.subscribeEndpoint())  // Subscribe in queue.
+	api.GET("/stream", q.streamEndpoint())        // Streams items of queue as server-sent events.
+	api.GET("/queue", q.copyEndpoint())           // Gets whole of queue.
+	api.POST("/mode", q.modeEndpoint())           // Changes delivery mode of queue.
+	api.POST("/consistency", q.levelEndpoint())   // Changes replicas confirming a push into queue.
+
+	api.GET("/groups", q.groupsEndpoint())                     // Gets consumer groups.
+	api.POST("/groups/:group", q.createGroupEndpoint())        // Creates a consumer group.
+	api.DELETE("/groups/:group", q.deleteGroupEndpoint())      // Deletes a consumer group.
+	api.POST("/groups/:group/reset", q.resetGroupEndpoint())   // Moves offset of a consumer group.
+	api.GET("/groups/:group/pull", q.consumeEndpoint())        // Gets items a consumer group did not read.
+	api.GET("/groups/:group/subscribe", q.subscribeEndpoint()) // Subscribe in a consumer group.
+	api.GET("/groups/:group/stream", q.streamEndpoint())       // Streams items of a consumer group as server-sent events.
+}
+
+func (q *Queue) modeEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q.service.SetMode(c)
+	}
+}
+
+func (q *Queue) levelEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q.service.SetConsistency(c)
+	}
+}
+
+func (q *Queue) groupsEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q.service.Groups(c)
+	}
+}
+
+func (q *Queue) createGroupEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q.service.CreateGroup(c)
+	}
+}
+
+func (q *Queue) deleteGroupEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q.service.DeleteGroup(c)
+	}
+}
+
+func (q *Queue) resetGroupEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q.service.ResetGroup(c)
+	}
+}
+
+func (q *Queue) consumeEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q.service.Consume(c)
+	}
+}
+
+func (q *Queue) queuesEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q.service.Queues(c)
+	}
+}
+
+func (q *Queue) createQueueEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q.service.CreateQueue(c)
+	}
+}
+
+func (q *Queue) deleteQueueEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q.service.DeleteQueue(c)
+	}
+}
+
+func (q *Queue) reconciliationEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q.service.Reconciliation(c)
+	}
+}
+
+func (q *Queue) reconcileEndpoint() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		q.service.Push(c, false)
+		q.service.Reconcile(c)
 	}
 }
 
-func (q *Queue) pushForceEndpoint() gin.HandlerFunc {
+func (q *Queue) pushEndpoint() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		q.service.Push(c, true)
+		q.service.Push(c)
 	}
 }
 
 func (q *Queue) pullEndpoint() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		q.service.Pull(c, false)
+		q.service.Pull(c)
+	}
+}
+
+func (q *Queue) peekEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q.service.Peek(c)
+	}
+}
+
+func (q *Queue) streamEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q.service.Stream(c)
 	}
 }
 
-func (q *Queue) pullForceEndpoint() gin.HandlerFunc {
+func (q *Queue) ackEndpoint() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		q.service.Pull(c, true)
+		q.service.Ack(c)
+	}
+}
+
+func (q *Queue) deadLetterEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q.service.DeadLetter(c)
+	}
+}
+
+func (q *Queue) redriveEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q.service.Redrive(c)
+	}
+}
+
+func (q *Queue) purgeEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q.service.Purge(c)
 	}
 }
 
@@ -77,102 +204,12 @@ var upgrader = websocket.Upgrader{
 
 func (q *Queue) subscribeEndpoint() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if q.st.Replica.Hostname[0] != "sad-server-1" {
-			fmt.Println("PROXY WS")
-			conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-			if err != nil {
-				log.Println("Failed to upgrade connection to WebSocket:", err)
-				c.AbortWithError(http.StatusInternalServerError, err)
-				return
-			}
-			defer conn.Close()
-
-			// Connect to another server via WebSocket
-			remoteAddr := fmt.Sprintf("ws://%s:8080/subscribe", q.helper.GetFirst()) // Replace with your remote server address
-			fmt.Println(remoteAddr)
-			remoteConn, _, err := websocket.DefaultDialer.Dial(remoteAddr, nil)
-			if err != nil {
-				log.Println("Failed to connect to remote server:", err)
-				c.AbortWithError(http.StatusInternalServerError, err)
-				return
-			}
-			fmt.Println("OPEN CONNECTION")
-			// Proxy messages between connections
-			go proxyMessages(conn, remoteConn)
-			for {
-				t, msg, err := conn.ReadMessage()
-				if err != nil {
-					logger.Error(err.Error(), conn, remoteConn)
-					conn.Close()
-					remoteConn.Close()
-					return
-				}
-
-				if err := remoteConn.WriteMessage(t, msg); err != nil {
-					logger.Error(err.Error(), conn, remoteConn)
-					conn.Close()
-					remoteConn.Close()
-					return
-				}
-			}
-
-		} else {
-			conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-			addr := conn.RemoteAddr().String()
-			if err != nil {
-				logger.Error(err.Error())
-				return
-			}
-			defer func() {
-				conn.Close()
-				err := q.service.Unsubscribe(conn, addr)
-				if err != nil {
-					logger.Error(err.Error())
-					return
-				}
-			}()
-
-			for {
-				_, msg, err := conn.ReadMessage()
-				if err != nil {
-					logger.Error(err.Error())
-					return
-				}
-
-				if bytes.Equal(msg, []byte("subscribe\n")) {
-					response := q.service.Subscribe(conn, addr)
-					if err := conn.WriteMessage(websocket.TextMessage, []byte(response)); err != nil {
-						logger.Error(err.Error())
-						return
-					}
-
-				} else {
-					if err := conn.WriteMessage(websocket.TextMessage, []byte("Invalid Message")); err != nil {
-						logger.Error(err.Error())
-						return
-					}
-				}
-			}
-		}
-	}
-}
-
-func proxyMessages(conn, remoteConn *websocket.Conn) {
-	for {
-		t, msg, err := remoteConn.ReadMessage()
+		ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 		if err != nil {
 			logger.Error(err.Error())
-			conn.Close()
-			remoteConn.Close()
-			return
-		}
-
-		if err := conn.WriteMessage(t, msg); err != nil {
-			logger.Error(err.Error())
-			conn.Close()
-			remoteConn.Close()
 			return
 		}
+		newSession(q.service, ws, c.Param("name"), c.Param("group")).serve()
 	}
 }
 
@@ -0,0 +1,71 @@
+package queue
+
+import (
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/raft"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/settings"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// LeaderHeader tells clients routed by a follower where the leader
+	// is, so they can reach it directly next time.
+	LeaderHeader = "X-Raft-Leader"
+	// forwardedHeader marks requests a replica passed to the leader, which
+	// are not passed on again if leadership moved meanwhile.
+	forwardedHeader = "X-Raft-Forwarded"
+)
+
+// cluster tells a replica whether it leads and where the leader is.
+type cluster interface {
+	IsLeader() bool
+	Leader() (string, error)
+}
+
+// routeToLeader lets the leader serve the request, and makes a follower
+// pass it to the leader or redirect the client there, as the routing
+// setting says. Clients get the same answers whichever replica they hit.
+func (q *Queue) routeToLeader() gin.HandlerFunc {
+	return routeToLeader(q.repository, q.repository.Raft().Status().ID, q.st)
+}
+
+// routeToLeader is Queue.routeToLeader for the replica id of cl.
+func routeToLeader(cl cluster, id string, st *settings.Settings) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cl.IsLeader() {
+			c.Next()
+			return
+		}
+		if c.GetHeader(forwardedHeader) != "" {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, raft.ErrNotLeader.Error())
+			return
+		}
+
+		leader, err := cl.Leader()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		host := net.JoinHostPort(leader, strconv.Itoa(st.Global.APIPort))
+		c.Header(LeaderHeader, host)
+
+		if st.Replica.Routing == settings.RoutingRedirect {
+			target := url.URL{Scheme: "http", Host: host, Path: c.Request.URL.Path, RawQuery: c.Request.URL.RawQuery}
+			c.Redirect(http.StatusTemporaryRedirect, target.String())
+			c.Abort()
+			return
+		}
+
+		// Streams and WebSocket subscriptions pass through as they come.
+		proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: host})
+		proxy.FlushInterval = -1
+		c.Request.Header.Set(forwardedHeader, id)
+		proxy.ServeHTTP(c.Writer, c.Request)
+		c.Abort()
+	}
+}
@@ -0,0 +1,224 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/raft"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/settings"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// fakeCluster is a replica that leads or knows where the leader is.
+type fakeCluster struct {
+	leader  bool
+	address string
+}
+
+func (f fakeCluster) IsLeader() bool { return f.leader }
+
+func (f fakeCluster) Leader() (string, error) {
+	if f.address == "" {
+		return "", raft.ErrNoLeader
+	}
+	return f.address, nil
+}
+
+// newLeader serves a fake leader answering pulls with what it was asked,
+// streams that hold until release is closed and WebSockets echoing what
+// they read.
+func newLeader(t *testing.T, release chan struct{}) (*httptest.Server, int) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pull", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"uri":       r.URL.RequestURI(),
+			"forwarded": r.Header.Get(forwardedHeader),
+		})
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+	})
+	mux.HandleFunc("/subscribe", func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		for {
+			mt, msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := ws.WriteMessage(mt, msg); err != nil {
+				return
+			}
+		}
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return srv, p
+}
+
+// newReplica serves the endpoints of the fake leader behind routeToLeader.
+func newReplica(t *testing.T, cl cluster, st *settings.Settings) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	v1 := router.Group("/", routeToLeader(cl, "node-2", st))
+	served := func(c *gin.Context) { c.JSON(http.StatusOK, "served here") }
+	v1.GET("/pull", served)
+	v1.GET("/stream", served)
+	v1.GET("/subscribe", served)
+
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRouteToLeader(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	_, port := newLeader(t, release)
+	leader := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+
+	var st settings.Settings
+	st.Global.APIPort = port
+	st.Replica.Routing = settings.RoutingProxy
+	follower := newReplica(t, fakeCluster{address: "127.0.0.1"}, &st)
+
+	t.Run("leader serves the request", func(t *testing.T) {
+		srv := newReplica(t, fakeCluster{leader: true}, &st)
+		resp, err := http.Get(srv.URL + "/pull")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var body string
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		if resp.StatusCode != http.StatusOK || body != "served here" || resp.Header.Get(LeaderHeader) != "" {
+			t.Fatalf("unexpected response %d %q", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("follower redirects to the leader", func(t *testing.T) {
+		redirect := st
+		redirect.Replica.Routing = settings.RoutingRedirect
+		srv := newReplica(t, fakeCluster{address: "127.0.0.1"}, &redirect)
+
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		resp, err := client.Get(srv.URL + "/pull?count=2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusTemporaryRedirect {
+			t.Fatalf("expected 307, got %d", resp.StatusCode)
+		}
+		if got := resp.Header.Get("Location"); got != "http://"+leader+"/pull?count=2" {
+			t.Fatalf("unexpected location %q", got)
+		}
+		if got := resp.Header.Get(LeaderHeader); got != leader {
+			t.Fatalf("unexpected leader header %q", got)
+		}
+	})
+
+	t.Run("follower proxies to the leader", func(t *testing.T) {
+		resp, err := http.Get(follower.URL + "/pull?count=2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var seen map[string]string
+		if err := json.NewDecoder(resp.Body).Decode(&seen); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected response %d %v", resp.StatusCode, err)
+		}
+		if seen["uri"] != "/pull?count=2" || seen["forwarded"] != "node-2" {
+			t.Fatalf("leader was asked %v", seen)
+		}
+		if got := resp.Header.Get(LeaderHeader); got != leader {
+			t.Fatalf("unexpected leader header %q", got)
+		}
+	})
+
+	t.Run("proxied streams are flushed as they come", func(t *testing.T) {
+		resp, err := http.Get(follower.URL + "/stream")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		// The leader holds the stream open, the event arrives before.
+		line, err := bufio.NewReader(resp.Body).ReadString('\n')
+		if err != nil || line != "data: first\n" {
+			t.Fatalf("unexpected event %q %v", line, err)
+		}
+	})
+
+	t.Run("proxied WebSockets are upgraded", func(t *testing.T) {
+		url := "ws" + strings.TrimPrefix(follower.URL, "http") + "/subscribe"
+		ws, resp, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+		if got := resp.Header.Get(LeaderHeader); got != leader {
+			t.Fatalf("unexpected leader header %q", got)
+		}
+
+		_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := ws.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if _, msg, err := ws.ReadMessage(); err != nil || string(msg) != "hello" {
+			t.Fatalf("unexpected echo %q %v", msg, err)
+		}
+	})
+
+	t.Run("forwarded requests are not passed on again", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, follower.URL+"/pull", nil)
+		req.Header.Set(forwardedHeader, "node-3")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var body string
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		if resp.StatusCode != http.StatusServiceUnavailable || body != raft.ErrNotLeader.Error() {
+			t.Fatalf("expected 503 %q, got %d %q", raft.ErrNotLeader, resp.StatusCode, body)
+		}
+	})
+
+	t.Run("follower without a leader is unavailable", func(t *testing.T) {
+		srv := newReplica(t, fakeCluster{}, &st)
+		resp, err := http.Get(srv.URL + "/pull")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("expected 503, got %d", resp.StatusCode)
+		}
+	})
+}
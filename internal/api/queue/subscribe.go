@@ -0,0 +1,198 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	service "github.com/System-Analysis-and-Design-2023-SUT/Server/internal/services/queue"
+	models "github.com/System-Analysis-and-Design-2023-SUT/Server/models/queue"
+	"github.com/gorilla/websocket"
+)
+
+// subscription is a queue, or a consumer group of it, a session subscribed
+// to.
+type subscription struct {
+	name  string
+	group string
+}
+
+// session serves a connection of subscribers on the leader. It speaks
+// plain text until the client says hello in JSON, see models.Command for
+// the JSON protocol.
+type session struct {
+	service *service.Service
+	ws      *websocket.Conn
+	conn    *models.Conn
+	addr    string
+	// name and group are the ones of the endpoint, used by commands that
+	// leave out the queue.
+	name  string
+	group string
+	// subscriptions are dropped once the client is gone.
+	subscriptions map[subscription]struct{}
+}
+
+func newSession(s *service.Service, ws *websocket.Conn, name string, group string) *session {
+	return &session{
+		service:       s,
+		ws:            ws,
+		conn:          models.NewConn(ws),
+		addr:          ws.RemoteAddr().String(),
+		name:          name,
+		group:         group,
+		subscriptions: make(map[subscription]struct{}),
+	}
+}
+
+// serve reads messages of the client until it is gone. The connection is
+// pinged, so a client that stops answering is dropped.
+func (s *session) serve() {
+	defer s.close()
+
+	_ = s.ws.SetReadDeadline(time.Now().Add(models.PongWait))
+	s.ws.SetPongHandler(func(string) error {
+		return s.ws.SetReadDeadline(time.Now().Add(models.PongWait))
+	})
+
+	for {
+		_, msg, err := s.ws.ReadMessage()
+		if err != nil {
+			logger.Error(err.Error())
+			return
+		}
+
+		if s.conn.Version() == 0 && !bytes.HasPrefix(bytes.TrimSpace(msg), []byte("{")) {
+			err = s.text(msg)
+		} else {
+			err = s.command(msg)
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			return
+		}
+	}
+}
+
+// text handles a message of the plain text protocol, "subscribe" or
+// "credit N", on the queue of the endpoint.
+func (s *session) text(msg []byte) error {
+	var response string
+	fields := strings.Fields(string(msg))
+	switch {
+	case len(fields) == 1 && fields[0] == "subscribe":
+		resp, err := s.subscribe(s.name, s.group, models.Subscription{})
+		if err != nil {
+			response = err.Error()
+		} else {
+			response = resp
+		}
+	case len(fields) == 2 && fields[0] == "credit":
+		n, err := strconv.Atoi(fields[1])
+		if err != nil {
+			response = models.ErrInvalidCredit.Error()
+		} else if err := s.service.Credit(s.name, s.group, s.addr, n); err != nil {
+			response = err.Error()
+		} else {
+			return nil
+		}
+	default:
+		response = "Invalid Message"
+	}
+	return s.conn.Write([]byte(response))
+}
+
+// command handles a message of the JSON protocol. Every command failing is
+// answered with an error event, and the others with an ok event if they
+// have a ref.
+func (s *session) command(msg []byte) error {
+	var cmd models.Command
+	if err := json.Unmarshal(msg, &cmd); err != nil {
+		return s.conn.WriteEvent(models.ErrorEvent("", models.ErrParseData))
+	}
+	if s.conn.Version() == 0 && cmd.Type != models.CommandHello {
+		return s.conn.WriteEvent(models.ErrorEvent(cmd.Ref, models.ErrHandshakeRequired))
+	}
+
+	name, group := cmd.Queue, cmd.Group
+	if name == "" {
+		name = s.name
+		if group == "" {
+			group = s.group
+		}
+	}
+
+	var err error
+	switch cmd.Type {
+	case models.CommandHello:
+		version, err := models.NegotiateVersion(cmd.Versions)
+		if err != nil {
+			e := models.ErrorEvent(cmd.Ref, err)
+			e.Versions = models.ProtocolVersions
+			return s.conn.WriteEvent(e)
+		}
+		s.conn.SetVersion(version)
+		return s.conn.WriteEvent(models.Event{
+			Type:     models.EventWelcome,
+			Ref:      cmd.Ref,
+			Version:  version,
+			Versions: models.ProtocolVersions,
+		})
+	case models.CommandSubscribe:
+		if cmd.Ack != "" && cmd.Ack != models.AckAuto && cmd.Ack != models.AckManual {
+			err = models.ErrInvalidCommand
+			break
+		}
+		_, err = s.subscribe(name, group, models.Subscription{
+			Filter: cmd.Filter,
+			Manual: cmd.Ack == models.AckManual,
+		})
+	case models.CommandUnsubscribe:
+		err = s.unsubscribe(name, group)
+	case models.CommandAck, models.CommandNack:
+		err = s.service.Settle(name, group, s.addr, cmd.ID, cmd.Type == models.CommandNack)
+	case models.CommandCredit:
+		err = s.service.Credit(name, group, s.addr, cmd.Credit)
+	case models.CommandPing:
+		return s.conn.WriteEvent(models.Event{Type: models.EventPong, Ref: cmd.Ref})
+	default:
+		err = models.ErrInvalidCommand
+	}
+
+	if err != nil {
+		return s.conn.WriteEvent(models.ErrorEvent(cmd.Ref, err))
+	}
+	if cmd.Ref != "" {
+		return s.conn.WriteEvent(models.Event{Type: models.EventOK, Ref: cmd.Ref})
+	}
+	return nil
+}
+
+func (s *session) subscribe(name string, group string, sub models.Subscription) (string, error) {
+	resp, err := s.service.Subscribe(name, group, s.conn, s.addr, sub)
+	if err != nil {
+		return "", err
+	}
+	s.subscriptions[subscription{name: name, group: group}] = struct{}{}
+	return resp, nil
+}
+
+func (s *session) unsubscribe(name string, group string) error {
+	err := s.service.Unsubscribe(name, group, s.addr)
+	delete(s.subscriptions, subscription{name: name, group: group})
+	return err
+}
+
+// close drops the subscriptions, giving back items not acked, and
+// disconnects the client.
+func (s *session) close() {
+	for sub := range s.subscriptions {
+		err := s.unsubscribe(sub.name, sub.group)
+		if err != nil && err != models.ErrNotSubscribed {
+			logger.Error(err.Error())
+		}
+	}
+	s.conn.Close()
+}
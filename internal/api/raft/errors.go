@@ -0,0 +1,5 @@
+package raft
+
+import "github.com/pkg/errors"
+
+var ErrNilRaftNode = errors.New("Raft node should not be nil")
@@ -0,0 +1,48 @@
+package raft
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/raft"
+	logging "github.com/System-Analysis-and-Design-2023-SUT/Server/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+var logger *logging.Logger
+
+func init() {
+	var err error
+	logger, err = logging.NewLogger("server_api_raft", true)
+	if err != nil {
+		log.Fatal("could not initialize server api raft module logger")
+	}
+}
+
+type Raft struct {
+	node *raft.Node
+}
+
+func (r *Raft) RegisterRoutes(v1 *gin.RouterGroup) {
+	logger.InfoS("Registering raft related endpoints to api server.")
+
+	api := v1.Group("/_raft")
+
+	api.GET("/status", r.statusEndpoint()) // Gets local view of the cluster.
+}
+
+func (r *Raft) statusEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, r.node.Status())
+	}
+}
+
+func NewRaftModule(node *raft.Node) (*Raft, error) {
+	if node == nil {
+		return nil, ErrNilRaftNode
+	}
+
+	return &Raft{
+		node: node,
+	}, nil
+}
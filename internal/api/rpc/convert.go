@@ -0,0 +1,123 @@
+package rpc
+
+import (
+	"time"
+
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/raft"
+	service "github.com/System-Analysis-and-Design-2023-SUT/Server/internal/services/queue"
+	models "github.com/System-Analysis-and-Design-2023-SUT/Server/models/queue"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/pkg/queuepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// codeOf maps errors of the queue to the status codes clients get, the
+// rest are internal.
+var codeOf = map[error]codes.Code{
+	models.ErrEmptyList:       codes.NotFound,
+	models.ErrKeyNotFound:     codes.NotFound,
+	models.ErrReceiptNotFound: codes.NotFound,
+	models.ErrQueueNotFound:   codes.NotFound,
+	models.ErrGroupNotFound:   codes.NotFound,
+
+	models.ErrKeyExist:   codes.AlreadyExists,
+	models.ErrQueueExist: codes.AlreadyExists,
+
+	models.ErrInvalidPriority:    codes.InvalidArgument,
+	models.ErrInvalidDelay:       codes.InvalidArgument,
+	models.ErrInvalidTTL:         codes.InvalidArgument,
+	models.ErrInvalidBatch:       codes.InvalidArgument,
+	models.ErrInvalidFilter:      codes.InvalidArgument,
+	models.ErrInvalidQueueName:   codes.InvalidArgument,
+	models.ErrInvalidGroupName:   codes.InvalidArgument,
+	models.ErrInvalidConsistency: codes.InvalidArgument,
+
+	models.ErrNotConfirmed: codes.Unavailable,
+
+	models.ErrConnClosed: codes.Aborted,
+
+	raft.ErrNoLeader:       codes.Unavailable,
+	raft.ErrNotLeader:      codes.Unavailable,
+	raft.ErrLeadershipLost: codes.Unavailable,
+	raft.ErrProposeTimeout: codes.Unavailable,
+}
+
+func toStatus(err error) error {
+	code, ok := codeOf[err]
+	if !ok {
+		code = codes.Internal
+	}
+	return status.Error(code, err.Error())
+}
+
+func timestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func fromData(d models.Data) *queuepb.Item {
+	return &queuepb.Item{
+		Key:            d.Key,
+		Value:          d.Value,
+		Deliveries:     int32(d.Deliveries),
+		Priority:       int32(d.Priority),
+		Headers:        d.Headers,
+		ContentType:    d.ContentType,
+		Producer:       d.Producer,
+		EnqueuedAt:     timestamppb.New(d.EnqueuedAt),
+		ExpiresAt:      timestamp(d.ExpiresAt),
+		DeliverAt:      timestamp(d.DeliverAt),
+		IdempotencyKey: d.IdempotencyKey,
+		Sequence:       d.Sequence,
+	}
+}
+
+func fromLease(l models.Lease) *queuepb.Lease {
+	return &queuepb.Lease{
+		Item:     fromData(l.Data),
+		Receipt:  l.Receipt,
+		Deadline: timestamppb.New(l.Deadline),
+	}
+}
+
+// toEntry reads an item to push. Like the HTTP API it takes deliverAt of
+// the item unless a delay is given.
+func toEntry(e *queuepb.Entry) service.Entry {
+	item := e.GetItem()
+	d := models.Data{
+		Key:            item.GetKey(),
+		Value:          item.GetValue(),
+		Priority:       int(item.GetPriority()),
+		Headers:        item.GetHeaders(),
+		ContentType:    item.GetContentType(),
+		Producer:       item.GetProducer(),
+		IdempotencyKey: item.GetIdempotencyKey(),
+	}
+	if item.GetDeliverAt() != nil {
+		deliverAt := item.GetDeliverAt().AsTime()
+		d.DeliverAt = &deliverAt
+	}
+	if item.GetExpiresAt() != nil {
+		expiresAt := item.GetExpiresAt().AsTime()
+		d.ExpiresAt = &expiresAt
+	}
+
+	return service.Entry{
+		Data:  d,
+		TTL:   e.GetTtl().AsDuration(),
+		Delay: e.GetDelay().AsDuration(),
+	}
+}
+
+func toFilter(f *queuepb.Filter) models.Filter {
+	return models.Filter{
+		KeyPrefix:   f.GetKeyPrefix(),
+		KeyPattern:  f.GetKeyPattern(),
+		Headers:     f.GetHeaders(),
+		ContentType: f.GetContentType(),
+		Producer:    f.GetProducer(),
+	}
+}
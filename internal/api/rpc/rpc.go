@@ -0,0 +1,198 @@
+package rpc
+
+import (
+	"context"
+	"log"
+
+	repo "github.com/System-Analysis-and-Design-2023-SUT/Server/internal/repository/queue"
+	service "github.com/System-Analysis-and-Design-2023-SUT/Server/internal/services/queue"
+	models "github.com/System-Analysis-and-Design-2023-SUT/Server/models/queue"
+	logging "github.com/System-Analysis-and-Design-2023-SUT/Server/pkg/logger"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/pkg/queuepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var logger *logging.Logger
+
+func init() {
+	var err error
+	logger, err = logging.NewLogger("server_api_rpc", true)
+	if err != nil {
+		log.Fatal("could not initialize server api rpc module logger")
+	}
+}
+
+// Queue serves the queue endpoints over gRPC, backed by the same service
+// and repository as the HTTP ones.
+type Queue struct {
+	queuepb.UnimplementedQueueServer
+
+	repository *repo.Repository
+	service    *service.Service
+}
+
+func (q *Queue) Register(s *grpc.Server) {
+	logger.InfoS("Registering queue service to rpc server.")
+	queuepb.RegisterQueueServer(s, q)
+}
+
+func (q *Queue) Push(ctx context.Context, req *queuepb.PushRequest) (*queuepb.Item, error) {
+	d, err := q.service.PushEntry(req.GetQueue(), toEntry(req.GetEntry()), req.GetConsistency())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return fromData(d), nil
+}
+
+func (q *Queue) PushBatch(ctx context.Context, req *queuepb.PushBatchRequest) (*queuepb.PushBatchResponse, error) {
+	entries := make([]service.Entry, len(req.GetEntries()))
+	for i, e := range req.GetEntries() {
+		entries[i] = toEntry(e)
+	}
+
+	results, err := q.service.PushEntries(req.GetQueue(), entries, req.GetConsistency())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &queuepb.PushBatchResponse{Results: make([]*queuepb.PushResult, len(results))}
+	for i, r := range results {
+		resp.Results[i] = &queuepb.PushResult{Key: r.Key, Error: r.Error, Duplicate: r.Duplicate, Sequence: r.Sequence}
+	}
+	return resp, nil
+}
+
+func (q *Queue) Pull(ctx context.Context, req *queuepb.PullRequest) (*queuepb.PullResponse, error) {
+	f := toFilter(req.GetFilter())
+	if err := f.Validate(); err != nil {
+		return nil, toStatus(err)
+	}
+	var filters []models.Filter
+	if !f.Empty() {
+		filters = append(filters, f)
+	}
+
+	count := int(req.GetCount())
+	resp := &queuepb.PullResponse{}
+	if req.GetLease() {
+		visibility := req.GetVisibility().AsDuration()
+		var leases []models.Lease
+		if count > 0 {
+			var err error
+			leases, err = q.repository.LeaseBatch(req.GetQueue(), visibility, count, filters...)
+			if err != nil {
+				return nil, toStatus(err)
+			}
+		} else {
+			l, err := q.repository.Lease(req.GetQueue(), visibility, filters...)
+			if err != nil {
+				return nil, toStatus(err)
+			}
+			leases = append(leases, l)
+		}
+		for _, l := range leases {
+			resp.Leases = append(resp.Leases, fromLease(l))
+		}
+		return resp, nil
+	}
+
+	var items []models.Data
+	if count > 0 {
+		var err error
+		items, err = q.repository.PullBatch(req.GetQueue(), count, filters...)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+	} else {
+		d, err := q.repository.Pull(req.GetQueue(), filters...)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		items = append(items, d)
+	}
+	for _, d := range items {
+		resp.Items = append(resp.Items, fromData(d))
+	}
+	return resp, nil
+}
+
+func (q *Queue) Ack(ctx context.Context, req *queuepb.AckRequest) (*queuepb.Item, error) {
+	d, err := q.repository.Ack(req.GetQueue(), req.GetReceipt())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return fromData(d), nil
+}
+
+func (q *Queue) Peek(ctx context.Context, req *queuepb.PeekRequest) (*queuepb.Item, error) {
+	d, err := q.repository.Peek(req.GetQueue())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return fromData(d), nil
+}
+
+// Subscribe sends items through a subscriber of the queue like the event
+// stream of the HTTP API does, so they are acked once sent.
+func (q *Queue) Subscribe(req *queuepb.SubscribeRequest, stream queuepb.Queue_SubscribeServer) error {
+	// Subscribers are served by the raft leader, clients reconnect to it.
+	if !q.repository.IsLeader() {
+		leader, err := q.repository.Leader()
+		if err != nil {
+			return toStatus(err)
+		}
+		return status.Errorf(codes.Unavailable, "subscribe to the leader at %s", leader)
+	}
+
+	f := toFilter(req.GetFilter())
+	if err := f.Validate(); err != nil {
+		return toStatus(err)
+	}
+
+	conn, stop, err := q.repository.Listen(req.GetQueue(), req.GetGroup(), models.Subscription{Filter: f})
+	if err != nil {
+		return toStatus(err)
+	}
+	defer stop()
+
+	for {
+		h, err := conn.Next(stream.Context().Done(), models.PingInterval)
+		if err == models.ErrEmptyList {
+			continue
+		}
+		if err != nil {
+			if ctxErr := stream.Context().Err(); ctxErr != nil {
+				return status.FromContextError(ctxErr).Err()
+			}
+			return toStatus(err)
+		}
+
+		err = stream.Send(&queuepb.Delivery{
+			Id:    h.ID(),
+			Queue: h.Queue,
+			Group: h.Group,
+			Item:  fromData(h.Data),
+		})
+		h.Written(err)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func NewQueueModule(repo *repo.Repository, service *service.Service) (*Queue, error) {
+	if repo == nil {
+		return nil, ErrNilQueueRepo
+	}
+
+	if service == nil {
+		return nil, ErrNilQueueService
+	}
+
+	return &Queue{
+		repository: repo,
+		service:    service,
+	}, nil
+}
@@ -0,0 +1,161 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	service "github.com/System-Analysis-and-Design-2023-SUT/Server/internal/services/queue"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/settings"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/testnode"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/pkg/queuepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newClient serves a single node cluster over an in-memory connection.
+func newClient(t *testing.T) queuepb.QueueClient {
+	var st settings.Settings
+	r := testnode.NewRepository(t, &st)
+
+	s, err := service.NewService(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := NewQueueModule(r, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	q.Register(srv)
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return ln.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return queuepb.NewQueueClient(conn)
+}
+
+func entry(key string, value string) *queuepb.Entry {
+	return &queuepb.Entry{Item: &queuepb.Item{Key: key, Value: value}}
+}
+
+func TestQueue(t *testing.T) {
+	client := newClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t.Run("pull from empty queue", func(t *testing.T) {
+		_, err := client.Pull(ctx, &queuepb.PullRequest{})
+		if status.Code(err) != codes.NotFound {
+			t.Fatalf("expected NotFound, got %v", err)
+		}
+	})
+
+	t.Run("push, peek and pull", func(t *testing.T) {
+		item, err := client.Push(ctx, &queuepb.PushRequest{Entry: entry("a", "1")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.GetKey() != "a" || item.GetEnqueuedAt() == nil || item.GetSequence() == 0 {
+			t.Fatalf("unexpected pushed item %v", item)
+		}
+		seq := item.GetSequence()
+
+		item, err = client.Peek(ctx, &queuepb.PeekRequest{})
+		if err != nil || item.GetKey() != "a" {
+			t.Fatalf("expected to peek a, got %v, %v", item, err)
+		}
+
+		resp, err := client.Pull(ctx, &queuepb.PullRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.GetItems()) != 1 || resp.GetItems()[0].GetValue() != "1" || resp.GetItems()[0].GetSequence() != seq {
+			t.Fatalf("expected to pull a, got %v", resp)
+		}
+	})
+
+	t.Run("push batch and lease", func(t *testing.T) {
+		resp, err := client.PushBatch(ctx, &queuepb.PushBatchRequest{
+			Entries: []*queuepb.Entry{entry("b", "2"), entry("c", "3"), entry("b", "2")},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.GetResults()) != 3 || !resp.GetResults()[2].GetDuplicate() {
+			t.Fatalf("expected last item to be a duplicate, got %v", resp)
+		}
+		results := resp.GetResults()
+		if results[0].GetSequence() == 0 || results[1].GetSequence() != results[0].GetSequence()+1 || results[2].GetSequence() != results[0].GetSequence() {
+			t.Fatalf("expected consecutive sequences and the original one for the duplicate, got %v", resp)
+		}
+
+		pulled, err := client.Pull(ctx, &queuepb.PullRequest{
+			Count:  5,
+			Lease:  true,
+			Filter: &queuepb.Filter{KeyPattern: "c*"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pulled.GetLeases()) != 1 || pulled.GetLeases()[0].GetItem().GetKey() != "c" {
+			t.Fatalf("expected to lease c, got %v", pulled)
+		}
+
+		receipt := pulled.GetLeases()[0].GetReceipt()
+		if _, err := client.Ack(ctx, &queuepb.AckRequest{Receipt: receipt}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Ack(ctx, &queuepb.AckRequest{Receipt: receipt}); status.Code(err) != codes.NotFound {
+			t.Fatalf("expected NotFound acking twice, got %v", err)
+		}
+	})
+
+	t.Run("invalid filter", func(t *testing.T) {
+		_, err := client.Pull(ctx, &queuepb.PullRequest{Filter: &queuepb.Filter{KeyPattern: "["}})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("expected InvalidArgument, got %v", err)
+		}
+	})
+
+	t.Run("subscribe", func(t *testing.T) {
+		stream, err := client.Subscribe(ctx, &queuepb.SubscribeRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// b is still in the queue and d arrives afterwards.
+		if _, err := client.Push(ctx, &queuepb.PushRequest{Entry: entry("d", "4")}); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"b", "d"} {
+			d, err := stream.Recv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.GetItem().GetKey() != key || d.GetId() == "" {
+				t.Fatalf("expected to receive %s, got %v", key, d)
+			}
+		}
+
+		if _, err := client.Peek(ctx, &queuepb.PeekRequest{}); status.Code(err) != codes.NotFound {
+			t.Fatalf("expected sent items to be acked, got %v", err)
+		}
+	})
+}
@@ -4,3 +4,4 @@ import "github.com/pkg/errors"
 
 var ErrNilHealthModule = errors.New("Health module should not be empty")
 var ErrNilQueueModule = errors.New("Queue module should not be empty")
+var ErrNilRaftModule = errors.New("Raft module should not be empty")
@@ -5,6 +5,7 @@ import (
 
 	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/api/health"
 	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/api/queue"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/api/raft"
 	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/settings"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -19,7 +20,7 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.engine.ServeHTTP(w, r)
 }
 
-func NewServer(queue *queue.Queue, healthMod *health.Health, settings *settings.Settings) (*Server, error) {
+func NewServer(queue *queue.Queue, healthMod *health.Health, raftMod *raft.Raft, settings *settings.Settings) (*Server, error) {
 	if healthMod == nil {
 		return nil, ErrNilHealthModule
 	}
@@ -28,6 +29,10 @@ func NewServer(queue *queue.Queue, healthMod *health.Health, settings *settings.
 		return nil, ErrNilQueueModule
 	}
 
+	if raftMod == nil {
+		return nil, ErrNilRaftModule
+	}
+
 	gin.SetMode(settings.Global.Environment) //todo
 	engine := gin.New()
 
@@ -43,6 +48,7 @@ func NewServer(queue *queue.Queue, healthMod *health.Health, settings *settings.
 	v1 := engine.Group("/")
 	healthMod.RegisterRoutes(v1)
 	queue.RegisterRoutes(v1)
+	raftMod.RegisterRoutes(v1)
 
 	return &Server{
 		environment: settings.Global.Environment,
@@ -3,5 +3,5 @@ package helper
 import "github.com/pkg/errors"
 
 var ErrNilMemberlist = errors.New("Helper memberlist should not be nil")
-var ErrQueueNotFound = errors.New("Cant get any queue from other nodes")
-var ErrNodesAreNotReachable = errors.New("Cant get any data from other nodes")
+var ErrInvalidPort = errors.New("Helper port should be positive")
+var ErrInvalidMeta = errors.New("Local memberlist node should carry its raft ID and address")
@@ -1,122 +1,204 @@
 package helper
 
 import (
-	"fmt"
-	"io"
-	"net/http"
+	"encoding/json"
+	"net"
+	"strconv"
+	"time"
 
-	models "github.com/System-Analysis-and-Design-2023-SUT/Server/models/queue"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/raft"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/pkg/wire"
 	"github.com/hashicorp/memberlist"
 )
 
+const (
+	methodVote     = "raft.vote"
+	methodAppend   = "raft.append"
+	methodSnapshot = "raft.snapshot"
+	methodPropose  = "raft.propose"
+
+	// poolSize connections are kept to each member, so a large snapshot
+	// does not hold heartbeats back.
+	poolSize    = 2
+	callTimeout = 10 * time.Second
+	retries     = 2
+)
+
+// Helper carries raft messages between the members of the memberlist over
+// framed connections on their gossoping port.
 type Helper struct {
-	list *memberlist.Memberlist
+	list   *memberlist.Memberlist
+	port   int
+	client *wire.Client
+	server *wire.Server
 }
 
-func (h *Helper) Read(data models.Data) error {
-	fmt.Println("PULLLL")
-	fmt.Println(h.list.Members())
-	for _, m := range h.list.Members() {
-		if m == h.list.LocalNode() {
-			continue
-		}
+// Meta is what a member tells the others about itself through the
+// memberlist. ID stays the same across restarts, unlike the name of the
+// member in the memberlist.
+type Meta struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+}
 
-		var address = string(m.Meta)
-		response, err := http.Get(fmt.Sprintf("http://%s:8080/_pull?key=%s", address, data.Key))
-		if err != nil {
-			fmt.Println(err)
-			continue
-		}
+// Encode returns m as the metadata of a memberlist node.
+func (m Meta) Encode() []byte {
+	b, _ := json.Marshal(m)
+	return b
+}
 
-		defer response.Body.Close()
+func decodeMeta(b []byte) (Meta, bool) {
+	var m Meta
+	if err := json.Unmarshal(b, &m); err != nil || m.ID == "" {
+		return Meta{}, false
 	}
-	return nil
+	return m, true
 }
 
-func (h *Helper) Write(data models.Data) error {
-	fmt.Println("PUSHHHH")
-	fmt.Println(h.list.Members())
-	for _, m := range h.list.Members() {
-		if m == h.list.LocalNode() {
+// LocalID is the raft ID of this node.
+func (h *Helper) LocalID() string {
+	m, _ := decodeMeta(h.list.LocalNode().Meta)
+	return m.ID
+}
+
+// Peers returns the other members by their raft ID. A restarted member
+// may still be listed under its previous name until the memberlist drops
+// it, the alive one is kept then.
+func (h *Helper) Peers() []raft.Peer {
+	local := h.LocalID()
+	peers := make([]raft.Peer, 0)
+	index := make(map[string]int)
+	for _, n := range h.list.Members() {
+		m, ok := decodeMeta(n.Meta)
+		if !ok || m.ID == local {
 			continue
 		}
-		if len(m.Name) > 6 && m.Name[:len(m.Name)-4] == "sad-server-1" {
+		p := raft.Peer{ID: m.ID, Address: m.Address}
+		if i, ok := index[m.ID]; ok {
+			if n.State == memberlist.StateAlive {
+				peers[i] = p
+			}
 			continue
 		}
+		index[m.ID] = len(peers)
+		peers = append(peers, p)
+	}
+	return peers
+}
 
-		var address = string(m.Meta)
-		response, err := http.Post(fmt.Sprintf("http://%s:8080/_push?key=%s&value=%s", address, data.Key, data.Value),
-			"application/json",
-			nil,
-		)
-		if err != nil {
-			fmt.Println(err)
-			continue
-		}
+func (h *Helper) RequestVote(peer raft.Peer, req *raft.VoteRequest) (*raft.VoteResponse, error) {
+	var resp raft.VoteResponse
+	return &resp, h.Call(peer, methodVote, req, &resp)
+}
 
-		defer response.Body.Close()
-	}
-	for _, m := range h.list.Members() {
-		if m == h.list.LocalNode() {
-			continue
-		}
-		if len(m.Name) > 6 && m.Name[:len(m.Name)-4] == "sad-server-1" {
-			var address = string(m.Meta)
-			response, err := http.Post(fmt.Sprintf("http://%s:8080/_push?key=%s&value=%s", address, data.Key, data.Value),
-				"application/json",
-				nil,
-			)
-			if err != nil {
-				fmt.Println(err)
-				continue
-			}
+func (h *Helper) AppendEntries(peer raft.Peer, req *raft.AppendRequest) (*raft.AppendResponse, error) {
+	var resp raft.AppendResponse
+	return &resp, h.Call(peer, methodAppend, req, &resp)
+}
 
-			defer response.Body.Close()
-		}
+func (h *Helper) InstallSnapshot(peer raft.Peer, req *raft.SnapshotRequest) (*raft.SnapshotResponse, error) {
+	var resp raft.SnapshotResponse
+	return &resp, h.Call(peer, methodSnapshot, req, &resp)
+}
+
+func (h *Helper) Propose(peer raft.Peer, req *raft.ProposeRequest) ([]byte, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return h.client.Call(h.address(peer), methodPropose, body)
 }
 
-func (h *Helper) GetQueue() ([]byte, error) {
-	for _, m := range h.list.Members() {
-		if m == h.list.LocalNode() {
-			continue
+// Register answers raft messages of the other members with node.
+func (h *Helper) Register(node *raft.Node) {
+	h.server.Handle(methodVote, func(body []byte) ([]byte, error) {
+		var req raft.VoteRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, err
 		}
-
-		var address = string(m.Meta)
-		response, err := http.Get(fmt.Sprintf("http://%s:8080/queue", address))
-		if err != nil {
-			continue
+		return json.Marshal(node.HandleVote(&req))
+	})
+	h.server.Handle(methodAppend, func(body []byte) ([]byte, error) {
+		var req raft.AppendRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, err
 		}
-		defer response.Body.Close()
-
-		body, err := io.ReadAll(response.Body)
-		if err != nil {
-			continue
+		return json.Marshal(node.HandleAppend(&req))
+	})
+	h.server.Handle(methodSnapshot, func(body []byte) ([]byte, error) {
+		var req raft.SnapshotRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, err
+		}
+		return json.Marshal(node.HandleSnapshot(&req))
+	})
+	h.server.Handle(methodPropose, func(body []byte) ([]byte, error) {
+		var req raft.ProposeRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, err
 		}
+		return node.HandlePropose(&req)
+	})
+}
 
-		return body, nil
-	}
+// Handle answers method of the other members with handler. The method
+// should only read, calls to it are retried like raft messages.
+func (h *Helper) Handle(method string, handler wire.Handler) {
+	h.client.Idempotent(method)
+	h.server.Handle(method, handler)
+}
 
-	return nil, ErrQueueNotFound
+// Serve answers the other members on ln until the helper is closed.
+func (h *Helper) Serve(ln net.Listener) error {
+	return h.server.Serve(ln)
 }
 
-func (h *Helper) GetFirst() string {
-	for _, m := range h.list.Members() {
-		if len(m.Name) > 6 && m.Name[:len(m.Name)-4] == "sad-server-1" {
-			return string(m.Meta)
-		}
+// Close drops the connections to and from the other members.
+func (h *Helper) Close() error {
+	h.client.Close()
+	return h.server.Close()
+}
+
+// Call sends req as JSON to method of peer and unmarshals the reply into
+// resp.
+func (h *Helper) Call(peer raft.Peer, method string, req interface{}, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	b, err := h.client.Call(h.address(peer), method, body)
+	if err != nil {
+		return err
 	}
-	fmt.Println("Can not get first node")
-	return ""
+	return json.Unmarshal(b, resp)
 }
 
-func NewHelper(list *memberlist.Memberlist) (*Helper, error) {
+func (h *Helper) address(peer raft.Peer) string {
+	return net.JoinHostPort(peer.Address, strconv.Itoa(h.port))
+}
+
+// NewHelper returns a helper reaching the other members on port.
+func NewHelper(list *memberlist.Memberlist, port int) (*Helper, error) {
 	if list == nil {
 		return nil, ErrNilMemberlist
 	}
+	if _, ok := decodeMeta(list.LocalNode().Meta); !ok {
+		return nil, ErrInvalidMeta
+	}
+	if port <= 0 {
+		return nil, ErrInvalidPort
+	}
+
+	// Raft tolerates repeated messages, a forwarded command would be
+	// committed twice though.
+	client := wire.NewClient(poolSize, callTimeout, retries)
+	client.Idempotent(methodVote, methodAppend, methodSnapshot)
 
 	return &Helper{
-		list: list,
+		list:   list,
+		port:   port,
+		client: client,
+		server: wire.NewServer(),
 	}, nil
 }
@@ -0,0 +1,17 @@
+package raft
+
+import "github.com/pkg/errors"
+
+var ErrNilFSM = errors.New("Raft state machine should not be nil")
+var ErrNilTransport = errors.New("Raft transport should not be nil")
+var ErrNotLeader = errors.New("Node is not the leader")
+var ErrNoLeader = errors.New("There is no leader in the cluster")
+var ErrLeadershipLost = errors.New("Leadership lost while committing the command")
+var ErrProposeTimeout = errors.New("Timed out while committing the command")
+var ErrStopped = errors.New("Raft node is stopped")
+var ErrNotReplicated = errors.New("Command was committed but not every node stored it in time")
+var ErrInvalidConsistency = errors.New("Consistency should be one, quorum or all")
+var ErrUnknownPeer = errors.New("Peer is not a member of the cluster")
+var ErrNotRepaired = errors.New("Follower could not be repaired with the snapshot")
+var ErrSnapshotInterrupted = errors.New("Follower lost track of the snapshot stream")
+var ErrNotCaughtUp = errors.New("Node has not caught up with the leader yet")
@@ -0,0 +1,980 @@
+package raft
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/pkg/wal"
+)
+
+const (
+	// maxAppendEntries caps how many entries are shipped in one
+	// AppendEntries.
+	maxAppendEntries = 512
+	// maxSnapshotChunk caps how much of a snapshot is shipped in one
+	// InstallSnapshot.
+	maxSnapshotChunk = 1 << 20
+)
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+func (r role) String() string {
+	switch r {
+	case leader:
+		return "leader"
+	case candidate:
+		return "candidate"
+	default:
+		return "follower"
+	}
+}
+
+type Config struct {
+	// ID identifies the node among its peers.
+	ID string
+	// ClusterSize is the expected number of voters; the quorum never drops
+	// below a majority of it, even if fewer members are alive.
+	ClusterSize int
+	// ElectionTimeout is the minimum time without a leader before starting
+	// an election; the actual timeout is randomized up to twice as much.
+	ElectionTimeout   time.Duration
+	HeartbeatInterval time.Duration
+	ProposeTimeout    time.Duration
+	// SnapshotInterval is the period of compacting the log into a snapshot.
+	SnapshotInterval time.Duration
+}
+
+type result struct {
+	data []byte
+	err  error
+}
+
+type waiter struct {
+	term uint64
+	ch   chan result
+}
+
+// incomingSnapshot collects the chunks of a snapshot the leader streams.
+type incomingSnapshot struct {
+	index uint64
+	term  uint64
+	data  []byte
+}
+
+// Node is a single member of a raft cluster. Commands proposed on any node
+// are committed through the leader's log and applied to the FSM of every
+// node in the same order.
+type Node struct {
+	mu sync.Mutex
+	// applyMu serializes every access to the FSM.
+	applyMu   sync.Mutex
+	config    Config
+	fsm       FSM
+	transport Transport
+	wal       *wal.Log
+
+	role     role
+	term     uint64
+	votedFor string
+	leaderID string
+	// log[0] holds the index and term covered by the last snapshot.
+	log         []Entry
+	snapshot    []byte
+	incoming    *incomingSnapshot
+	commitIndex uint64
+	lastApplied uint64
+	// leaderCommit is the commit index the leader told this node last, and
+	// caughtUp is set once this node applied as much.
+	leaderCommit uint64
+	caughtUp     bool
+
+	nextIndex  map[string]uint64
+	matchIndex map[string]uint64
+	// matched is closed and replaced whenever matchIndex grows.
+	matched  chan struct{}
+	inflight map[string]bool
+	waiters  map[uint64]waiter
+
+	lastContact time.Time
+	timeout     time.Duration
+	// restoring is set while a snapshot of the leader is restored, which
+	// holds back elections.
+	restoring bool
+
+	applyCh chan struct{}
+	stopCh  chan struct{}
+	stopped bool
+}
+
+// NewNode creates a node and restores its state from w. A nil w keeps
+// everything in memory.
+func NewNode(config Config, fsm FSM, transport Transport, w *wal.Log) (*Node, error) {
+	if fsm == nil {
+		return nil, ErrNilFSM
+	}
+	if transport == nil {
+		return nil, ErrNilTransport
+	}
+
+	n := &Node{
+		config:    config,
+		fsm:       fsm,
+		transport: transport,
+		wal:       w,
+		log:       []Entry{{}},
+		waiters:   make(map[uint64]waiter),
+		matched:   make(chan struct{}),
+		applyCh:   make(chan struct{}, 1),
+		stopCh:    make(chan struct{}),
+	}
+	if err := n.restore(); err != nil {
+		return nil, err
+	}
+	n.resetTimeout()
+	return n, nil
+}
+
+// Start runs the election, replication and apply loops.
+func (n *Node) Start() {
+	go n.run()
+	go n.applyLoop()
+}
+
+func (n *Node) Stop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped {
+		return
+	}
+	n.stopped = true
+	close(n.stopCh)
+	n.failWaiters(0, ErrStopped)
+}
+
+// Propose commits command through the leader and returns what the FSM
+// produced for it, once as many nodes as consistency asks for stored it.
+// Followers forward the command to the leader. With ConsistencyAll the
+// result comes along ErrNotReplicated if some node did not store the
+// command in time, which is committed nonetheless.
+func (n *Node) Propose(command []byte, consistency Consistency) ([]byte, error) {
+	if !consistency.IsValid() {
+		return nil, ErrInvalidConsistency
+	}
+	deadline := time.Now().Add(n.config.ProposeTimeout)
+
+	for time.Now().Before(deadline) {
+		n.mu.Lock()
+		if n.stopped {
+			n.mu.Unlock()
+			return nil, ErrStopped
+		}
+		if n.role == leader {
+			n.mu.Unlock()
+			return n.propose(command, consistency, deadline)
+		}
+		leaderID := n.leaderID
+		n.mu.Unlock()
+
+		if leaderID != "" {
+			if peer, ok := n.peer(leaderID); ok {
+				return n.transport.Propose(peer, &ProposeRequest{Command: command, Consistency: consistency})
+			}
+		}
+
+		// An election is probably going on.
+		time.Sleep(n.config.HeartbeatInterval)
+	}
+	return nil, ErrNoLeader
+}
+
+// HandlePropose commits a command forwarded by a follower. Followers that
+// predate consistency levels ask for a quorum.
+func (n *Node) HandlePropose(req *ProposeRequest) ([]byte, error) {
+	consistency := req.Consistency
+	if consistency == "" {
+		consistency = ConsistencyQuorum
+	}
+	if !consistency.IsValid() {
+		return nil, ErrInvalidConsistency
+	}
+	return n.propose(req.Command, consistency, time.Now().Add(n.config.ProposeTimeout))
+}
+
+func (n *Node) propose(command []byte, consistency Consistency, deadline time.Time) ([]byte, error) {
+	n.mu.Lock()
+	if n.role != leader {
+		n.mu.Unlock()
+		return nil, ErrNotLeader
+	}
+
+	e := Entry{Index: n.lastIndex() + 1, Term: n.term, Command: command}
+	if err := n.persistEntries([]Entry{e}); err != nil {
+		n.mu.Unlock()
+		return nil, err
+	}
+	n.log = append(n.log, e)
+
+	if consistency == ConsistencyOne {
+		n.advanceCommit()
+		n.mu.Unlock()
+		n.broadcast()
+		return nil, nil
+	}
+
+	ch := make(chan result, 1)
+	n.waiters[e.Index] = waiter{term: e.Term, ch: ch}
+	n.advanceCommit()
+	n.mu.Unlock()
+
+	n.broadcast()
+
+	var r result
+	select {
+	case r = <-ch:
+	case <-time.After(time.Until(deadline)):
+		n.mu.Lock()
+		delete(n.waiters, e.Index)
+		n.mu.Unlock()
+		return nil, ErrProposeTimeout
+	}
+	if r.err != nil || consistency != ConsistencyAll {
+		return r.data, r.err
+	}
+	return r.data, n.waitReplicated(e.Index, deadline)
+}
+
+// waitReplicated waits until every node of the cluster stored the entry at
+// index, or fails with ErrNotReplicated once deadline passes.
+func (n *Node) waitReplicated(index uint64, deadline time.Time) error {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	for {
+		n.mu.Lock()
+		if n.role != leader {
+			n.mu.Unlock()
+			return ErrNotReplicated
+		}
+		peers := n.transport.Peers()
+		stored := 0
+		for _, p := range peers {
+			if n.matchIndex[p.ID] >= index {
+				stored++
+			}
+		}
+		// Like the quorum, the cluster is never smaller than ClusterSize.
+		if stored == len(peers) && stored+1 >= n.config.ClusterSize {
+			n.mu.Unlock()
+			return nil
+		}
+		matched := n.matched
+		n.mu.Unlock()
+
+		select {
+		case <-matched:
+		case <-timer.C:
+			return ErrNotReplicated
+		}
+	}
+}
+
+// notifyMatched wakes up the proposals waiting for matchIndex to grow.
+// Callers should hold n.mu.
+func (n *Node) notifyMatched() {
+	close(n.matched)
+	n.matched = make(chan struct{})
+}
+
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role == leader
+}
+
+// Leader returns the current leader as known by this node. The address of
+// the peer is empty when this node is the leader.
+func (n *Node) Leader() (Peer, bool) {
+	n.mu.Lock()
+	leaderID := n.leaderID
+	n.mu.Unlock()
+
+	if leaderID == "" {
+		return Peer{}, false
+	}
+	if leaderID == n.config.ID {
+		return Peer{ID: leaderID}, true
+	}
+	return n.peer(leaderID)
+}
+
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return Status{
+		ID:           n.config.ID,
+		State:        n.role.String(),
+		Term:         n.term,
+		Leader:       n.leaderID,
+		LastIndex:    n.lastIndex(),
+		CommitIndex:  n.commitIndex,
+		AppliedIndex: n.lastApplied,
+	}
+}
+
+// Ready fails until the node applied everything the leader had committed
+// when it last heard from it, so a joining node is not served before it
+// caught up. Once caught up the node stays ready.
+func (n *Node) Ready() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if !n.caughtUp {
+		switch {
+		case n.role == leader:
+			n.caughtUp = n.lastApplied >= n.commitIndex
+		case n.leaderID != "" && time.Since(n.lastContact) < n.timeout:
+			n.caughtUp = n.lastApplied >= n.leaderCommit
+		}
+	}
+	if !n.caughtUp {
+		return ErrNotCaughtUp
+	}
+	return nil
+}
+
+func (n *Node) HandleVote(req *VoteRequest) *VoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// A candidate with a stale log does not hold elections back, so the
+	// timer is only reset once the vote is granted.
+	if req.Term > n.term {
+		n.adoptTerm(req.Term)
+	}
+
+	granted := false
+	if req.Term == n.term && (n.votedFor == "" || n.votedFor == req.CandidateID) && n.upToDate(req.LastLogIndex, req.LastLogTerm) {
+		n.votedFor = req.CandidateID
+		if err := n.persistState(); err == nil {
+			granted = true
+			n.lastContact = time.Now()
+		}
+	}
+	return &VoteResponse{Term: n.term, Granted: granted}
+}
+
+func (n *Node) HandleAppend(req *AppendRequest) *AppendResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return &AppendResponse{Term: n.term, LastIndex: n.lastIndex()}
+	}
+	if req.Term > n.term || n.role != follower {
+		n.stepDown(req.Term)
+	}
+	n.leaderID = req.LeaderID
+	n.lastContact = time.Now()
+	n.leaderCommit = req.LeaderCommit
+
+	if req.PrevLogIndex > n.lastIndex() {
+		return &AppendResponse{Term: n.term, LastIndex: n.lastIndex()}
+	}
+
+	entries := req.Entries
+	if req.PrevLogIndex < n.log[0].Index {
+		// Everything up to the snapshot is committed and so matches.
+		skip := n.log[0].Index - req.PrevLogIndex
+		if uint64(len(entries)) <= skip {
+			return &AppendResponse{Term: n.term, Success: true, LastIndex: n.lastIndex()}
+		}
+		entries = entries[skip:]
+	} else if n.entry(req.PrevLogIndex).Term != req.PrevLogTerm {
+		// Committed entries always match the leader, so it can resume right
+		// after them.
+		return &AppendResponse{Term: n.term, LastIndex: n.commitIndex}
+	}
+
+	for i, e := range entries {
+		if e.Index <= n.lastIndex() {
+			if n.entry(e.Index).Term == e.Term {
+				continue
+			}
+			if err := n.persistTruncate(e.Index); err != nil {
+				return &AppendResponse{Term: n.term, LastIndex: n.commitIndex}
+			}
+			n.truncate(e.Index)
+		}
+		if err := n.persistEntries(entries[i:]); err != nil {
+			return &AppendResponse{Term: n.term, LastIndex: n.commitIndex}
+		}
+		n.log = append(n.log, entries[i:]...)
+		break
+	}
+
+	last := req.PrevLogIndex + uint64(len(req.Entries))
+	// A delayed request may cover less than is committed already.
+	if commit := min(req.LeaderCommit, last); commit > n.commitIndex {
+		n.commitIndex = commit
+		n.notifyApply()
+	}
+	return &AppendResponse{Term: n.term, Success: true, LastIndex: last}
+}
+
+// HandleSnapshot collects a chunk of the snapshot the leader streams and
+// restores the snapshot once it is complete. The follower then catches up
+// with the entries after it like any other.
+func (n *Node) HandleSnapshot(req *SnapshotRequest) *SnapshotResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return &SnapshotResponse{Term: n.term}
+	}
+	if req.Term > n.term || n.role != follower {
+		n.stepDown(req.Term)
+	}
+	n.leaderID = req.LeaderID
+	n.lastContact = time.Now()
+	n.leaderCommit = max(n.leaderCommit, req.LastIndex)
+	if req.LastIndex <= n.lastApplied && !n.repairable(req) {
+		n.incoming = nil
+		return &SnapshotResponse{Term: n.term, Next: req.Size}
+	}
+
+	in := n.incoming
+	if in == nil || req.Offset == 0 || in.index != req.LastIndex || in.term != req.LastTerm {
+		in = &incomingSnapshot{index: req.LastIndex, term: req.LastTerm}
+		n.incoming = in
+	}
+	if req.Offset != uint64(len(in.data)) {
+		return &SnapshotResponse{Term: n.term, Next: uint64(len(in.data))}
+	}
+	in.data = append(in.data, req.Data...)
+	if uint64(len(in.data)) < req.Size {
+		return &SnapshotResponse{Term: n.term, Next: uint64(len(in.data))}
+	}
+	n.incoming = nil
+
+	n.mu.Unlock()
+	resp := n.installSnapshot(req, in.data)
+	n.mu.Lock()
+	return resp
+}
+
+// installSnapshot restores the complete snapshot data described by req.
+func (n *Node) installSnapshot(req *SnapshotRequest, data []byte) *SnapshotResponse {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	// Entries may have been applied while the chunks arrived.
+	n.mu.Lock()
+	if req.Term != n.term || (req.LastIndex <= n.lastApplied && !n.repairable(req)) {
+		defer n.mu.Unlock()
+		return &SnapshotResponse{Term: n.term, Next: req.Size}
+	}
+	// The leader waits for the restore, it did not go silent meanwhile.
+	n.restoring = true
+	n.mu.Unlock()
+
+	err := n.fsm.Restore(data)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.restoring = false
+	n.lastContact = time.Now()
+	if err != nil {
+		return &SnapshotResponse{Term: n.term}
+	}
+
+	if req.LastIndex <= n.lastIndex() && n.entry(req.LastIndex).Term == req.LastTerm {
+		n.log = n.log[req.LastIndex-n.log[0].Index:]
+	} else {
+		n.failWaiters(n.log[0].Index+1, ErrLeadershipLost)
+		n.log = []Entry{{}}
+	}
+	n.log[0] = Entry{Index: req.LastIndex, Term: req.LastTerm}
+	n.snapshot = data
+	n.commitIndex = max(n.commitIndex, req.LastIndex)
+	n.lastApplied = req.LastIndex
+	_ = n.persistSnapshot()
+	// A repaired follower applies again what it committed after the
+	// snapshot.
+	n.notifyApply()
+	return &SnapshotResponse{Term: n.term, Installed: true, Next: req.Size}
+}
+
+// streamSnapshot sends the snapshot of req to p in chunks. It stops early
+// when p answers with a newer term, and fails when p lost track of the
+// stream, which is sent again from the start next time.
+func (n *Node) streamSnapshot(p Peer, req *SnapshotRequest) (*SnapshotResponse, error) {
+	data := req.Data
+	size := uint64(len(data))
+	var offset uint64
+	for {
+		end := min(size, offset+maxSnapshotChunk)
+		chunk := *req
+		chunk.Offset, chunk.Size, chunk.Data = offset, size, data[offset:end]
+
+		resp, err := n.transport.InstallSnapshot(p, &chunk)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Term > req.Term || resp.Next >= size {
+			return resp, nil
+		}
+		if resp.Next != end {
+			return nil, ErrSnapshotInterrupted
+		}
+		offset = end
+	}
+}
+
+// repairable reports whether the follower can restore a repair snapshot it
+// applied already, which takes the entries after it still in the log.
+// Callers should hold n.mu.
+func (n *Node) repairable(req *SnapshotRequest) bool {
+	return req.Repair && req.LastIndex >= n.log[0].Index && req.LastIndex <= n.lastIndex() && n.entry(req.LastIndex).Term == req.LastTerm
+}
+
+// Repair replaces the state of the follower id with a fresh snapshot of the
+// leader, for a follower whose state diverged although its log matches.
+// The follower applies again the entries it committed after the snapshot.
+func (n *Node) Repair(id string) error {
+	p, ok := n.peer(id)
+	if !ok {
+		return ErrUnknownPeer
+	}
+	if !n.IsLeader() {
+		return ErrNotLeader
+	}
+	n.takeSnapshot()
+
+	n.mu.Lock()
+	if n.role != leader {
+		n.mu.Unlock()
+		return ErrNotLeader
+	}
+	term := n.term
+	req := &SnapshotRequest{
+		Term:      term,
+		LeaderID:  n.config.ID,
+		LastIndex: n.log[0].Index,
+		LastTerm:  n.log[0].Term,
+		Data:      n.snapshot,
+		Repair:    true,
+	}
+	n.mu.Unlock()
+
+	resp, err := n.streamSnapshot(p, req)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if resp.Term > n.term {
+		n.stepDown(resp.Term)
+		return ErrLeadershipLost
+	}
+	if !resp.Installed {
+		return ErrNotRepaired
+	}
+	return nil
+}
+
+// run drives elections on followers and heartbeats on the leader.
+func (n *Node) run() {
+	ticker := time.NewTicker(n.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		n.mu.Lock()
+		r := n.role
+		expired := !n.restoring && time.Since(n.lastContact) >= n.timeout
+		n.mu.Unlock()
+
+		if r == leader {
+			n.broadcast()
+		} else if expired {
+			n.startElection()
+		}
+	}
+}
+
+func (n *Node) startElection() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.role = candidate
+	n.term++
+	n.votedFor = n.config.ID
+	n.leaderID = ""
+	n.lastContact = time.Now()
+	n.resetTimeout()
+	if err := n.persistState(); err != nil {
+		return
+	}
+
+	term := n.term
+	req := &VoteRequest{
+		Term:         term,
+		CandidateID:  n.config.ID,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.log[len(n.log)-1].Term,
+	}
+	peers := n.transport.Peers()
+	quorum := n.quorum(len(peers))
+
+	votes := 1
+	if votes >= quorum {
+		n.becomeLeader()
+		return
+	}
+
+	for _, p := range peers {
+		go func(p Peer) {
+			resp, err := n.transport.RequestVote(p, req)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if resp.Term > n.term {
+				n.stepDown(resp.Term)
+				return
+			}
+			if n.role != candidate || n.term != term || !resp.Granted {
+				return
+			}
+			votes++
+			if votes >= quorum {
+				n.becomeLeader()
+			}
+		}(p)
+	}
+}
+
+func (n *Node) becomeLeader() {
+	n.role = leader
+	n.leaderID = n.config.ID
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.inflight = make(map[string]bool)
+
+	// Entries of previous terms are only committed along with one of the
+	// current term, so a no-op is appended right away.
+	e := Entry{Index: n.lastIndex() + 1, Term: n.term}
+	if err := n.persistEntries([]Entry{e}); err != nil {
+		n.stepDown(n.term)
+		return
+	}
+	n.log = append(n.log, e)
+	n.advanceCommit()
+
+	go n.broadcast()
+}
+
+// stepDown turns the node into a follower of term.
+func (n *Node) stepDown(term uint64) {
+	n.adoptTerm(term)
+	n.lastContact = time.Now()
+	n.resetTimeout()
+}
+
+// adoptTerm turns the node into a follower of term without resetting its
+// election timer.
+func (n *Node) adoptTerm(term uint64) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.leaderID = ""
+		_ = n.persistState()
+	}
+	n.role = follower
+}
+
+func (n *Node) broadcast() {
+	for _, p := range n.transport.Peers() {
+		n.replicate(p)
+	}
+}
+
+// replicate sends the next batch of entries, or the snapshot if they are
+// already compacted, to p unless a request to p is in flight.
+func (n *Node) replicate(p Peer) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.role != leader || n.inflight[p.ID] {
+		return
+	}
+
+	next, ok := n.nextIndex[p.ID]
+	if !ok {
+		next = n.lastIndex() + 1
+		n.nextIndex[p.ID] = next
+	}
+	n.inflight[p.ID] = true
+	term := n.term
+
+	if next <= n.log[0].Index {
+		req := &SnapshotRequest{
+			Term:      term,
+			LeaderID:  n.config.ID,
+			LastIndex: n.log[0].Index,
+			LastTerm:  n.log[0].Term,
+			Data:      n.snapshot,
+		}
+		go n.sendSnapshot(p, term, req)
+		return
+	}
+
+	last := min(n.lastIndex(), next+maxAppendEntries-1)
+	entries := make([]Entry, last-next+1)
+	copy(entries, n.log[next-n.log[0].Index:])
+	req := &AppendRequest{
+		Term:         term,
+		LeaderID:     n.config.ID,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  n.entry(next - 1).Term,
+		Entries:      entries,
+		LeaderCommit: n.commitIndex,
+	}
+	go n.sendAppend(p, term, req)
+}
+
+func (n *Node) sendAppend(p Peer, term uint64, req *AppendRequest) {
+	resp, err := n.transport.AppendEntries(p, req)
+
+	n.mu.Lock()
+	n.inflight[p.ID] = false
+	if err != nil {
+		n.mu.Unlock()
+		return
+	}
+	if resp.Term > n.term {
+		n.stepDown(resp.Term)
+		n.mu.Unlock()
+		return
+	}
+	if n.role != leader || n.term != term {
+		n.mu.Unlock()
+		return
+	}
+
+	more := false
+	if resp.Success {
+		match := req.PrevLogIndex + uint64(len(req.Entries))
+		n.matchIndex[p.ID] = max(n.matchIndex[p.ID], match)
+		n.nextIndex[p.ID] = match + 1
+		n.notifyMatched()
+		n.advanceCommit()
+		more = match < n.lastIndex()
+	} else {
+		n.nextIndex[p.ID] = max(1, min(resp.LastIndex+1, req.PrevLogIndex))
+		more = true
+	}
+	n.mu.Unlock()
+
+	if more {
+		n.replicate(p)
+	}
+}
+
+func (n *Node) sendSnapshot(p Peer, term uint64, req *SnapshotRequest) {
+	resp, err := n.streamSnapshot(p, req)
+
+	n.mu.Lock()
+	n.inflight[p.ID] = false
+	if err != nil {
+		n.mu.Unlock()
+		return
+	}
+	if resp.Term > n.term {
+		n.stepDown(resp.Term)
+		n.mu.Unlock()
+		return
+	}
+	if n.role != leader || n.term != term {
+		n.mu.Unlock()
+		return
+	}
+	n.matchIndex[p.ID] = max(n.matchIndex[p.ID], req.LastIndex)
+	n.nextIndex[p.ID] = req.LastIndex + 1
+	n.notifyMatched()
+	n.mu.Unlock()
+
+	n.replicate(p)
+}
+
+// advanceCommit commits the highest entry of the current term stored on a
+// quorum of nodes.
+func (n *Node) advanceCommit() {
+	quorum := n.quorum(len(n.transport.Peers()))
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if n.entry(index).Term != n.term {
+			break
+		}
+
+		count := 1
+		for _, match := range n.matchIndex {
+			if match >= index {
+				count++
+			}
+		}
+		if count >= quorum {
+			n.commitIndex = index
+			n.notifyApply()
+			return
+		}
+	}
+}
+
+func (n *Node) applyLoop() {
+	ticker := time.NewTicker(n.config.SnapshotInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-n.applyCh:
+			n.applyCommitted()
+		case <-ticker.C:
+			n.takeSnapshot()
+		}
+	}
+}
+
+func (n *Node) applyCommitted() {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	for {
+		n.mu.Lock()
+		if n.lastApplied >= n.commitIndex {
+			n.mu.Unlock()
+			return
+		}
+		e := n.entry(n.lastApplied + 1)
+		n.mu.Unlock()
+
+		var data []byte
+		if e.Command != nil {
+			data = n.fsm.Apply(e.Command)
+		}
+
+		n.mu.Lock()
+		n.lastApplied = e.Index
+		if w, ok := n.waiters[e.Index]; ok {
+			delete(n.waiters, e.Index)
+			if w.term == e.Term {
+				w.ch <- result{data: data}
+			} else {
+				w.ch <- result{err: ErrLeadershipLost}
+			}
+		}
+		n.mu.Unlock()
+	}
+}
+
+// takeSnapshot compacts every applied entry into a snapshot of the FSM.
+func (n *Node) takeSnapshot() {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.Lock()
+	index := n.lastApplied
+	if index == n.log[0].Index {
+		n.mu.Unlock()
+		return
+	}
+	term := n.entry(index).Term
+	n.mu.Unlock()
+
+	data, err := n.fsm.Snapshot()
+	if err != nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.log = n.log[index-n.log[0].Index:]
+	n.log[0] = Entry{Index: index, Term: term}
+	n.snapshot = data
+	_ = n.persistSnapshot()
+}
+
+func (n *Node) notifyApply() {
+	select {
+	case n.applyCh <- struct{}{}:
+	default:
+	}
+}
+
+// failWaiters fails every pending proposal from index onwards.
+func (n *Node) failWaiters(index uint64, err error) {
+	for i, w := range n.waiters {
+		if i >= index {
+			delete(n.waiters, i)
+			w.ch <- result{err: err}
+		}
+	}
+}
+
+// truncate drops every entry from index onwards.
+func (n *Node) truncate(index uint64) {
+	if index > n.lastIndex() {
+		return
+	}
+	n.failWaiters(index, ErrLeadershipLost)
+	n.log = n.log[:index-n.log[0].Index]
+}
+
+func (n *Node) peer(id string) (Peer, bool) {
+	for _, p := range n.transport.Peers() {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return Peer{}, false
+}
+
+// quorum is a majority of the cluster, which is at least ClusterSize big.
+func (n *Node) quorum(peers int) int {
+	return max(n.config.ClusterSize, peers+1)/2 + 1
+}
+
+func (n *Node) upToDate(index, term uint64) bool {
+	lastTerm := n.log[len(n.log)-1].Term
+	return term > lastTerm || (term == lastTerm && index >= n.lastIndex())
+}
+
+func (n *Node) entry(index uint64) Entry {
+	return n.log[index-n.log[0].Index]
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+func (n *Node) resetTimeout() {
+	n.timeout = n.config.ElectionTimeout + time.Duration(rand.Int63n(int64(n.config.ElectionTimeout)))
+}
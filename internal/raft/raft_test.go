@@ -0,0 +1,481 @@
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/pkg/wal"
+)
+
+// listFSM records every applied command.
+type listFSM struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func (f *listFSM) Apply(command []byte) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.list = append(f.list, string(command))
+	return command
+}
+
+func (f *listFSM) Snapshot() ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return json.Marshal(f.list)
+}
+
+func (f *listFSM) Restore(snapshot []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return json.Unmarshal(snapshot, &f.list)
+}
+
+func (f *listFSM) items() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.list...)
+}
+
+// network connects in-process nodes and can cut any of them off.
+type network struct {
+	mu    sync.Mutex
+	nodes map[string]*Node
+	down  map[string]bool
+}
+
+type transport struct {
+	net *network
+	id  string
+}
+
+func (t *transport) Peers() []Peer {
+	t.net.mu.Lock()
+	defer t.net.mu.Unlock()
+
+	peers := make([]Peer, 0)
+	for id := range t.net.nodes {
+		if id != t.id && !t.net.down[id] {
+			peers = append(peers, Peer{ID: id, Address: id})
+		}
+	}
+	return peers
+}
+
+func (t *transport) target(p Peer) (*Node, error) {
+	t.net.mu.Lock()
+	defer t.net.mu.Unlock()
+
+	if t.net.down[t.id] || t.net.down[p.ID] {
+		return nil, fmt.Errorf("%s is unreachable from %s", p.ID, t.id)
+	}
+	return t.net.nodes[p.ID], nil
+}
+
+func (t *transport) RequestVote(p Peer, req *VoteRequest) (*VoteResponse, error) {
+	n, err := t.target(p)
+	if err != nil {
+		return nil, err
+	}
+	return n.HandleVote(req), nil
+}
+
+func (t *transport) AppendEntries(p Peer, req *AppendRequest) (*AppendResponse, error) {
+	n, err := t.target(p)
+	if err != nil {
+		return nil, err
+	}
+	return n.HandleAppend(req), nil
+}
+
+func (t *transport) InstallSnapshot(p Peer, req *SnapshotRequest) (*SnapshotResponse, error) {
+	n, err := t.target(p)
+	if err != nil {
+		return nil, err
+	}
+	return n.HandleSnapshot(req), nil
+}
+
+func (t *transport) Propose(p Peer, req *ProposeRequest) ([]byte, error) {
+	n, err := t.target(p)
+	if err != nil {
+		return nil, err
+	}
+	return n.HandlePropose(req)
+}
+
+func newCluster(t *testing.T, size int) (*network, map[string]*listFSM) {
+	net := &network{nodes: make(map[string]*Node), down: make(map[string]bool)}
+	fsms := make(map[string]*listFSM)
+
+	for i := 1; i <= size; i++ {
+		id := fmt.Sprintf("node-%d", i)
+		fsms[id] = &listFSM{}
+		n, err := NewNode(Config{
+			ID:                id,
+			ClusterSize:       size,
+			ElectionTimeout:   50 * time.Millisecond,
+			HeartbeatInterval: 10 * time.Millisecond,
+			ProposeTimeout:    time.Second,
+			SnapshotInterval:  30 * time.Millisecond,
+		}, fsms[id], &transport{net: net, id: id}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		net.nodes[id] = n
+	}
+	for _, n := range net.nodes {
+		n.Start()
+		t.Cleanup(n.Stop)
+	}
+	return net, fsms
+}
+
+func waitLeader(t *testing.T, net *network) string {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		// Nodes look for peers under their own lock, so they are asked
+		// outside of the one of the network.
+		net.mu.Lock()
+		up := make(map[string]*Node, len(net.nodes))
+		for id, n := range net.nodes {
+			if !net.down[id] {
+				up[id] = n
+			}
+		}
+		net.mu.Unlock()
+
+		for id, n := range up {
+			if n.IsLeader() {
+				return id
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no leader was elected")
+	return ""
+}
+
+func waitItems(t *testing.T, fsm *listFSM, want []string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if fmt.Sprint(fsm.items()) == fmt.Sprint(want) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("got %v, want %v", fsm.items(), want)
+}
+
+func TestFailover(t *testing.T) {
+	net, fsms := newCluster(t, 3)
+
+	acked := make([]string, 0)
+	propose := func(via string, msg string) {
+		net.mu.Lock()
+		n := net.nodes[via]
+		net.mu.Unlock()
+
+		if _, err := n.Propose([]byte(msg), ConsistencyQuorum); err == nil {
+			acked = append(acked, msg)
+		}
+	}
+
+	first := waitLeader(t, net)
+	for i := 0; i < 20; i++ {
+		propose(first, fmt.Sprintf("a%d", i))
+	}
+	if len(acked) != 20 {
+		t.Fatalf("only %d of 20 messages were acknowledged", len(acked))
+	}
+
+	net.mu.Lock()
+	net.down[first] = true
+	net.mu.Unlock()
+
+	second := waitLeader(t, net)
+	if second == first {
+		t.Fatal("isolated node is still the leader")
+	}
+
+	// Proposals through the follower are forwarded to the new leader.
+	var follower string
+	for id := range net.nodes {
+		if id != first && id != second {
+			follower = id
+		}
+	}
+	for i := 0; i < 20; i++ {
+		propose(follower, fmt.Sprintf("b%d", i))
+	}
+	if len(acked) != 40 {
+		t.Fatalf("only %d of 40 messages were acknowledged", len(acked))
+	}
+
+	net.mu.Lock()
+	net.down[first] = false
+	net.mu.Unlock()
+
+	for _, fsm := range fsms {
+		waitItems(t, fsm, acked)
+	}
+}
+
+func TestSingleNode(t *testing.T) {
+	net, fsms := newCluster(t, 1)
+
+	leader := waitLeader(t, net)
+	resp, err := net.nodes[leader].Propose([]byte("x"), ConsistencyQuorum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "x" {
+		t.Fatalf("unexpected result %q", resp)
+	}
+	waitItems(t, fsms[leader], []string{"x"})
+}
+
+func TestRestart(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		ID:                "node-1",
+		ClusterSize:       1,
+		ElectionTimeout:   50 * time.Millisecond,
+		HeartbeatInterval: 10 * time.Millisecond,
+		ProposeTimeout:    time.Second,
+		SnapshotInterval:  time.Hour,
+	}
+	net := &network{nodes: make(map[string]*Node), down: make(map[string]bool)}
+
+	start := func() (*Node, *listFSM, *wal.Log) {
+		w, err := wal.Open(dir, wal.SyncAlways, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsm := &listFSM{}
+		n, err := NewNode(config, fsm, &transport{net: net, id: config.ID}, w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The node stopped before may still be looking for peers.
+		net.mu.Lock()
+		net.nodes[config.ID] = n
+		net.mu.Unlock()
+		n.Start()
+		waitLeader(t, net)
+		return n, fsm, w
+	}
+
+	n, _, w := start()
+	_, _ = n.Propose([]byte("a"), ConsistencyQuorum)
+	n.takeSnapshot()
+	_, _ = n.Propose([]byte("b"), ConsistencyQuorum)
+	n.Stop()
+	w.Close()
+
+	n, fsm, w := start()
+	defer w.Close()
+	defer n.Stop()
+	waitItems(t, fsm, []string{"a", "b"})
+}
+
+func TestConsistency(t *testing.T) {
+	net, fsms := newCluster(t, 3)
+	leader := waitLeader(t, net)
+	n := net.nodes[leader]
+
+	var followers []string
+	for id := range net.nodes {
+		if id != leader {
+			followers = append(followers, id)
+		}
+	}
+	cut := func(id string, down bool) {
+		net.mu.Lock()
+		net.down[id] = down
+		net.mu.Unlock()
+	}
+
+	for _, c := range []Consistency{ConsistencyOne, ConsistencyQuorum, ConsistencyAll} {
+		if _, err := n.Propose([]byte(c), c); err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+	}
+	if _, err := n.Propose([]byte("x"), "most"); err != ErrInvalidConsistency {
+		t.Fatalf("expected invalid consistency, got %v", err)
+	}
+
+	// A replica dies in the middle of a write that waits for every one of
+	// them, a quorum is still there.
+	done := make(chan error, 1)
+	go func() {
+		_, err := n.Propose([]byte("all"), ConsistencyAll)
+		done <- err
+	}()
+	cut(followers[0], true)
+	if err := <-done; err != nil && err != ErrNotReplicated {
+		t.Fatalf("expected the write to succeed or not be replicated, got %v", err)
+	}
+	if _, err := n.Propose([]byte("all"), ConsistencyAll); err != ErrNotReplicated {
+		t.Fatalf("expected all to fail without a replica, got %v", err)
+	}
+	if _, err := n.Propose([]byte("quorum"), ConsistencyQuorum); err != nil {
+		t.Fatalf("expected quorum to succeed without a replica, got %v", err)
+	}
+
+	// Without a quorum only the leader confirms.
+	cut(followers[1], true)
+	if _, err := n.Propose([]byte("one"), ConsistencyOne); err != nil {
+		t.Fatalf("expected one to succeed without a quorum, got %v", err)
+	}
+	if _, err := n.Propose([]byte("lost"), ConsistencyQuorum); err != ErrProposeTimeout {
+		t.Fatalf("expected quorum to time out, got %v", err)
+	}
+
+	// Once the replicas are back they may elect another leader, which takes
+	// writes confirmed by all of them again.
+	cut(followers[0], false)
+	cut(followers[1], false)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := net.nodes[waitLeader(t, net)].Propose([]byte("back"), ConsistencyAll)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected all to succeed again, got %v", err)
+		}
+	}
+	for id, fsm := range fsms {
+		for {
+			items := fsm.items()
+			if len(items) > 0 && items[len(items)-1] == "back" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s did not apply the last write, has %v", id, items)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestRepair(t *testing.T) {
+	net, fsms := newCluster(t, 3)
+
+	leader := waitLeader(t, net)
+	want := []string{"a", "b", "c"}
+	for _, msg := range want {
+		if _, err := net.nodes[leader].Propose([]byte(msg), ConsistencyAll); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var follower string
+	for id := range net.nodes {
+		if id != leader {
+			follower = id
+			break
+		}
+	}
+	waitItems(t, fsms[follower], want)
+
+	// The log of the follower matches, its state does not.
+	fsms[follower].mu.Lock()
+	fsms[follower].list = []string{"a", "x"}
+	fsms[follower].mu.Unlock()
+
+	if err := net.nodes[leader].Repair(follower); err != nil {
+		t.Fatal(err)
+	}
+	waitItems(t, fsms[follower], want)
+
+	if err := net.nodes[leader].Repair("node-9"); err != ErrUnknownPeer {
+		t.Fatalf("expected unknown peer, got %v", err)
+	}
+	if err := net.nodes[follower].Repair(leader); err != ErrNotLeader {
+		t.Fatalf("expected not leader, got %v", err)
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	net, fsms := newCluster(t, 3)
+
+	// node-3 joins late, after the log it missed is compacted into a
+	// snapshot larger than a chunk.
+	net.mu.Lock()
+	net.down["node-3"] = true
+	net.mu.Unlock()
+
+	leader := waitLeader(t, net)
+	want := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		msg := fmt.Sprintf("%d%s", i, strings.Repeat("x", maxSnapshotChunk/2))
+		if _, err := net.nodes[leader].Propose([]byte(msg), ConsistencyQuorum); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, msg)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := net.nodes["node-3"].Ready(); err != ErrNotCaughtUp {
+		t.Fatalf("expected node-3 not caught up, got %v", err)
+	}
+
+	net.mu.Lock()
+	net.down["node-3"] = false
+	net.mu.Unlock()
+
+	waitItems(t, fsms["node-3"], want)
+	deadline := time.Now().Add(5 * time.Second)
+	for net.nodes["node-3"].Ready() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("node-3 did not report ready after catching up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStaleMessages(t *testing.T) {
+	net := &network{nodes: make(map[string]*Node), down: make(map[string]bool)}
+	n, err := NewNode(Config{
+		ID:                "node-1",
+		ClusterSize:       3,
+		ElectionTimeout:   time.Hour,
+		HeartbeatInterval: time.Minute,
+		ProposeTimeout:    time.Second,
+		SnapshotInterval:  time.Hour,
+	}, &listFSM{}, &transport{net: net, id: "node-1"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []Entry{{Index: 1, Term: 1}, {Index: 2, Term: 1}, {Index: 3, Term: 1}}
+	resp := n.HandleAppend(&AppendRequest{Term: 1, LeaderID: "node-2", Entries: entries, LeaderCommit: 3})
+	if !resp.Success || n.Status().CommitIndex != 3 {
+		t.Fatalf("entries were not committed: %+v", n.Status())
+	}
+
+	// A delayed request covering fewer entries does not lower the commit
+	// index.
+	n.HandleAppend(&AppendRequest{Term: 1, LeaderID: "node-2", Entries: entries[:1], LeaderCommit: 3})
+	if n.Status().CommitIndex != 3 {
+		t.Fatalf("commit index went back to %d", n.Status().CommitIndex)
+	}
+
+	// A candidate with a stale log moves the term on but does not hold the
+	// election timer back.
+	n.mu.Lock()
+	n.lastContact = time.Time{}
+	n.mu.Unlock()
+	vote := n.HandleVote(&VoteRequest{Term: 5, CandidateID: "node-3", LastLogIndex: 1, LastLogTerm: 1})
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if vote.Granted || n.term != 5 || !n.lastContact.IsZero() {
+		t.Fatalf("stale candidate was granted %v, term %d, contact %v", vote.Granted, n.term, n.lastContact)
+	}
+}
@@ -0,0 +1,132 @@
+package raft
+
+import (
+	"encoding/json"
+)
+
+const (
+	recordState    = "state"
+	recordEntry    = "entry"
+	recordTruncate = "truncate"
+)
+
+// record is a single entry of the write-ahead log backing a node.
+type record struct {
+	Type  string `json:"type"`
+	Term  uint64 `json:"term,omitempty"`
+	Vote  string `json:"vote,omitempty"`
+	Entry *Entry `json:"entry,omitempty"`
+	Index uint64 `json:"index,omitempty"`
+}
+
+// snapshot is what the write-ahead log keeps as its snapshot.
+type snapshot struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Data  []byte `json:"data"`
+}
+
+// restore loads the persisted snapshot, hard state and log.
+func (n *Node) restore() error {
+	if n.wal == nil {
+		return nil
+	}
+
+	b, records, err := n.wal.Load()
+	if err != nil {
+		return err
+	}
+
+	if b != nil {
+		var s snapshot
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		if err := n.fsm.Restore(s.Data); err != nil {
+			return err
+		}
+		n.log = []Entry{{Index: s.Index, Term: s.Term}}
+		n.snapshot = s.Data
+		n.commitIndex = s.Index
+		n.lastApplied = s.Index
+	}
+
+	for _, b := range records {
+		var r record
+		if err := json.Unmarshal(b, &r); err != nil {
+			return err
+		}
+
+		switch r.Type {
+		case recordState:
+			n.term = r.Term
+			n.votedFor = r.Vote
+		case recordTruncate:
+			n.truncate(r.Index)
+		case recordEntry:
+			if r.Entry.Index <= n.log[0].Index || r.Entry.Index > n.lastIndex()+1 {
+				continue
+			}
+			n.truncate(r.Entry.Index)
+			n.log = append(n.log, *r.Entry)
+		}
+	}
+	return nil
+}
+
+func (n *Node) persistState() error {
+	return n.persist(record{Type: recordState, Term: n.term, Vote: n.votedFor})
+}
+
+func (n *Node) persistEntries(entries []Entry) error {
+	for i := range entries {
+		if err := n.persist(record{Type: recordEntry, Entry: &entries[i]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *Node) persistTruncate(index uint64) error {
+	return n.persist(record{Type: recordTruncate, Index: index})
+}
+
+// persistSnapshot stores the current snapshot and rewrites the log with
+// the hard state and the entries that follow it.
+func (n *Node) persistSnapshot() error {
+	if n.wal == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(snapshot{Index: n.log[0].Index, Term: n.log[0].Term, Data: n.snapshot})
+	if err != nil {
+		return err
+	}
+
+	tail := make([][]byte, 0, len(n.log))
+	state, err := json.Marshal(record{Type: recordState, Term: n.term, Vote: n.votedFor})
+	if err != nil {
+		return err
+	}
+	tail = append(tail, state)
+	for i := 1; i < len(n.log); i++ {
+		e, err := json.Marshal(record{Type: recordEntry, Entry: &n.log[i]})
+		if err != nil {
+			return err
+		}
+		tail = append(tail, e)
+	}
+	return n.wal.Compact(b, tail...)
+}
+
+func (n *Node) persist(r record) error {
+	if n.wal == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return n.wal.Append(b)
+}
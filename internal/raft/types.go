@@ -0,0 +1,121 @@
+package raft
+
+// FSM is the replicated state machine that committed commands are applied to.
+// Apply is called in log order from a single goroutine; Snapshot is never
+// called concurrently with Apply or Restore.
+type FSM interface {
+	Apply(command []byte) []byte
+	Snapshot() ([]byte, error)
+	Restore(snapshot []byte) error
+}
+
+// Peer is another member of the cluster.
+type Peer struct {
+	ID      string
+	Address string
+}
+
+// Transport delivers raft messages to other members and tells who they are.
+type Transport interface {
+	Peers() []Peer
+	RequestVote(peer Peer, req *VoteRequest) (*VoteResponse, error)
+	AppendEntries(peer Peer, req *AppendRequest) (*AppendResponse, error)
+	InstallSnapshot(peer Peer, req *SnapshotRequest) (*SnapshotResponse, error)
+	// Propose forwards a command to the leader and returns its result.
+	Propose(peer Peer, req *ProposeRequest) ([]byte, error)
+}
+
+// Consistency is how many nodes should store a command before proposing
+// it succeeds.
+type Consistency string
+
+const (
+	// ConsistencyOne succeeds once the leader stored the command, before
+	// it is committed. Proposing returns no result then.
+	ConsistencyOne Consistency = "one"
+	// ConsistencyQuorum succeeds once a majority stored the command and
+	// it is applied.
+	ConsistencyQuorum Consistency = "quorum"
+	// ConsistencyAll succeeds once every node of the cluster stored the
+	// command and it is applied.
+	ConsistencyAll Consistency = "all"
+)
+
+func (c Consistency) IsValid() bool {
+	return c == ConsistencyOne || c == ConsistencyQuorum || c == ConsistencyAll
+}
+
+// ProposeRequest is a command a follower forwards to the leader.
+type ProposeRequest struct {
+	Command     []byte      `json:"command"`
+	Consistency Consistency `json:"consistency,omitempty"`
+}
+
+type Entry struct {
+	Index   uint64 `json:"index"`
+	Term    uint64 `json:"term"`
+	Command []byte `json:"command,omitempty"`
+}
+
+type VoteRequest struct {
+	Term         uint64 `json:"term"`
+	CandidateID  string `json:"candidateId"`
+	LastLogIndex uint64 `json:"lastLogIndex"`
+	LastLogTerm  uint64 `json:"lastLogTerm"`
+}
+
+type VoteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+type AppendRequest struct {
+	Term         uint64  `json:"term"`
+	LeaderID     string  `json:"leaderId"`
+	PrevLogIndex uint64  `json:"prevLogIndex"`
+	PrevLogTerm  uint64  `json:"prevLogTerm"`
+	Entries      []Entry `json:"entries"`
+	LeaderCommit uint64  `json:"leaderCommit"`
+}
+
+type AppendResponse struct {
+	Term    uint64 `json:"term"`
+	Success bool   `json:"success"`
+	// LastIndex is where the leader should continue from after a rejection.
+	LastIndex uint64 `json:"lastIndex"`
+}
+
+type SnapshotRequest struct {
+	Term      uint64 `json:"term"`
+	LeaderID  string `json:"leaderId"`
+	LastIndex uint64 `json:"lastIndex"`
+	LastTerm  uint64 `json:"lastTerm"`
+	// The snapshot is Size bytes long and streamed in chunks, Data starts
+	// at Offset within it.
+	Offset uint64 `json:"offset,omitempty"`
+	Size   uint64 `json:"size"`
+	Data   []byte `json:"data"`
+	// Repair installs the snapshot on a follower that applied it already,
+	// which applies again the entries after it.
+	Repair bool `json:"repair,omitempty"`
+}
+
+type SnapshotResponse struct {
+	Term uint64 `json:"term"`
+	// Installed tells whether the follower restored the snapshot.
+	Installed bool `json:"installed,omitempty"`
+	// Next is how much of the snapshot the follower holds, or Size once it
+	// needs no more of it.
+	Next uint64 `json:"next"`
+}
+
+// Status describes the local view of the cluster.
+type Status struct {
+	ID           string `json:"id"`
+	State        string `json:"state"`
+	Term         uint64 `json:"term"`
+	Leader       string `json:"leader"`
+	LastIndex    uint64 `json:"lastIndex"`
+	CommitIndex  uint64 `json:"commitIndex"`
+	AppliedIndex uint64 `json:"appliedIndex"`
+}
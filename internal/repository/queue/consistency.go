@@ -0,0 +1,104 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/raft"
+	models "github.com/System-Analysis-and-Design-2023-SUT/Server/models/queue"
+)
+
+// consistency returns level, or the consistency level of the queue if
+// level is empty, or the default one if the queue has none either.
+func (r *Repository) consistency(name string, level string) (raft.Consistency, error) {
+	if !models.ValidConsistency(level) {
+		return "", models.ErrInvalidConsistency
+	}
+	if level == "" {
+		r.mu.Lock()
+		q, err := r.queue(name)
+		if err == nil {
+			level = q.Consistency()
+		}
+		r.mu.Unlock()
+	}
+	if level == "" {
+		level = r.st.Queue.Consistency
+	}
+	if level == "" {
+		level = models.ConsistencyQuorum
+	}
+	return raft.Consistency(level), nil
+}
+
+// write commits the push cmd at level and unmarshals its result into v.
+// applied is false with ConsistencyOne, which returns before the push is
+// applied and leaves v alone. A push too few replicas confirmed is rolled
+// back and fails with ErrNotConfirmed, the rollback going on in background
+// until it commits if it could not commit at once.
+func (r *Repository) write(cmd command, level string, v interface{}) (applied bool, err error) {
+	consistency, err := r.consistency(cmd.Queue, level)
+	if err != nil {
+		return false, err
+	}
+
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := r.node.Propose(b, consistency)
+	if err != nil {
+		if !unconfirmed(err) {
+			return false, err
+		}
+		r.rollback(cmd)
+		return false, models.ErrNotConfirmed
+	}
+	if consistency == raft.ConsistencyOne {
+		return false, nil
+	}
+	return true, decodeResult(resp, v)
+}
+
+// unconfirmed reports whether err leaves the command possibly committed,
+// just not confirmed by enough replicas. Errors forwarded by the leader
+// arrive as their message.
+func unconfirmed(err error) bool {
+	for _, e := range []error{raft.ErrNotReplicated, raft.ErrProposeTimeout, raft.ErrLeadershipLost} {
+		if err == e || err.Error() == e.Error() {
+			return true
+		}
+	}
+	return false
+}
+
+// rollback takes back the items of the push cmd if it got committed after
+// all. The revoke follows the push in the raft log, or the push is never
+// committed, so it applies to every replica the same way. Revoking is
+// retried in background, a ProposeTimeout apart, until it commits.
+func (r *Repository) rollback(cmd command) {
+	items := cmd.Items
+	if cmd.Op == opPush {
+		items = []models.Data{cmd.Data}
+	}
+	revoke := command{Op: opRevoke, Queue: cmd.Queue, Items: items}
+
+	var revoked []models.Data
+	err := r.propose(revoke, &revoked)
+	if err == nil || err == models.ErrQueueNotFound {
+		return
+	}
+
+	fmt.Println("Could not roll back push into queue yet", queueName(cmd.Queue), err)
+	go func() {
+		for {
+			time.Sleep(r.st.Consensus.ProposeTimeout)
+			err := r.propose(revoke, &revoked)
+			if err == nil || err == models.ErrQueueNotFound {
+				return
+			}
+		}
+	}()
+}
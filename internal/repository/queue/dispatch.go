@@ -0,0 +1,216 @@
+package queue
+
+import (
+	"fmt"
+	"time"
+
+	models "github.com/System-Analysis-and-Design-2023-SUT/Server/models/queue"
+)
+
+// expireInterval is how often the leader looks for expired leases and
+// items.
+const expireInterval = time.Second
+
+// notify marks queue name as possibly having items for its subscribers.
+// Callers should hold r.mu.
+func (r *Repository) notify(name string) {
+	r.pending[name] = struct{}{}
+	select {
+	case r.dispatch <- struct{}{}:
+	default:
+	}
+}
+
+// dispatchLoop hands queued items to subscribers. Only the leader does it,
+// since it is the one subscribers are connected to. Items are leased like
+// for any other consumer and acked once written, or once the subscriber
+// acked it if it acks by hand, so a failed write counts as a delivery
+// attempt and the item is not lost if the leader dies meanwhile. An item
+// no subscriber could be handed goes back without counting. Writes happen
+// in the writers of the subscribers, the loop only hands items to the ones
+// with room and credit for them and whose filter picks them.
+func (r *Repository) dispatchLoop() {
+	for range r.dispatch {
+		r.mu.Lock()
+		pending := r.pending
+		r.pending = make(map[string]struct{})
+		r.mu.Unlock()
+
+		for name := range pending {
+			r.dispatchQueue(name)
+			r.dispatchGroups(name)
+		}
+	}
+}
+
+func (r *Repository) dispatchQueue(name string) {
+	for r.node.IsLeader() {
+		r.mu.Lock()
+		s, ok := r.subscribers[name]
+		q, err := r.queue(name)
+		if err == nil && !q.Ready(time.Now()) {
+			err = models.ErrEmptyList
+		}
+		r.mu.Unlock()
+		if !ok || err != nil || !s.Ready() {
+			return
+		}
+
+		l, err := r.Lease(name, 0, s.Filters()...)
+		if err != nil {
+			if err != models.ErrEmptyList {
+				fmt.Println(err)
+			}
+			return
+		}
+
+		err = s.Send(models.Delivery{Queue: name, Data: l.Data, Receipt: l.Receipt, Deadline: l.Deadline})
+		if err != nil {
+			// Nothing was written, the item was not delivered.
+			_, err = r.Return(name, l.Receipt)
+			if err != nil && err != models.ErrReceiptNotFound && err != models.ErrQueueNotFound {
+				fmt.Println(err)
+			}
+			return
+		}
+	}
+}
+
+// queueDone acks items of queue name settled by a subscriber and releases
+// the ones that were not.
+func (r *Repository) queueDone(name string) models.DoneFunc {
+	return func(d models.Delivery, err error) {
+		if d.Receipt != "" {
+			if err == nil {
+				_, err = r.Ack(name, d.Receipt)
+			} else {
+				_, err = r.Release(name, d.Receipt)
+			}
+			if err != nil && err != models.ErrReceiptNotFound && err != models.ErrQueueNotFound {
+				fmt.Println(err)
+			}
+		}
+
+		r.mu.Lock()
+		r.notify(name)
+		r.mu.Unlock()
+	}
+}
+
+// dispatchGroups hands every consumer group of the queue the items it did
+// not read yet, one at a time so they arrive in order. An item is
+// committed once settled, so a failed write, a nack or a new leader sends
+// it again. Items no subscriber of the group picks are skipped.
+func (r *Repository) dispatchGroups(name string) {
+	r.mu.Lock()
+	q, err := r.queue(name)
+	groups := make(map[string]*models.Subscriber, len(r.groupSubscribers[name]))
+	for group, s := range r.groupSubscribers[name] {
+		if !r.busyGroups[groupKey(name, group)] {
+			groups[group] = s
+		}
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	for group, s := range groups {
+		if !r.node.IsLeader() || !s.Ready() {
+			continue
+		}
+
+		records, err := q.Fetch(group, 1)
+		if err != nil || len(records) == 0 {
+			continue
+		}
+
+		r.mu.Lock()
+		r.busyGroups[groupKey(name, group)] = true
+		r.mu.Unlock()
+
+		d := models.Delivery{Queue: name, Group: group, Data: records[0].Data, Offset: records[0].Offset}
+		if !s.Wants(d.Data) {
+			r.groupDone(name, group)(d, nil)
+			continue
+		}
+		err = s.Send(d)
+		if err == models.ErrExpired {
+			r.groupDone(name, group)(d, nil)
+		} else if err != nil {
+			// The subscribers picking the item are busy, one of them lets
+			// the group go on once it settled what it has.
+			r.mu.Lock()
+			delete(r.busyGroups, groupKey(name, group))
+			r.mu.Unlock()
+		}
+	}
+}
+
+// groupDone commits items of a consumer group settled by a subscriber and
+// lets the group be handed the next one.
+func (r *Repository) groupDone(name string, group string) models.DoneFunc {
+	return func(d models.Delivery, err error) {
+		if err == nil {
+			err = r.propose(command{Op: opCommit, Queue: name, Group: group, Offset: d.Offset}, nil)
+			if err != nil && err != models.ErrGroupNotFound && err != models.ErrQueueNotFound {
+				fmt.Println(err)
+			}
+		}
+
+		r.mu.Lock()
+		delete(r.busyGroups, groupKey(name, group))
+		r.notify(name)
+		r.mu.Unlock()
+	}
+}
+
+func groupKey(name string, group string) string {
+	return name + "/" + group
+}
+
+// expireLoop makes the leader return items with an expired lease to the
+// head of their queue, reap items that outlived their TTL and wake up the
+// subscribers of scheduled items that came due. Expiry and reaping go
+// through the raft log, so every node drops the same items.
+func (r *Repository) expireLoop() {
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !r.node.IsLeader() {
+			continue
+		}
+
+		now := time.Now()
+		r.mu.Lock()
+		expired := make([]string, 0)
+		stale := make([]string, 0)
+		for name, q := range r.queues {
+			if q.Expired(now) {
+				expired = append(expired, name)
+			}
+			if q.Stale(now) {
+				stale = append(stale, name)
+			}
+			// Scheduled items that came due wait for subscribers too.
+			if q.Delayed() > 0 && q.Ready(now) {
+				r.notify(name)
+			}
+		}
+		r.mu.Unlock()
+
+		for _, name := range expired {
+			err := r.propose(command{Op: opExpire, Queue: name, Time: now, MaxDeliveries: r.st.Queue.MaxDeliveries}, nil)
+			if err != nil && err != models.ErrQueueNotFound {
+				fmt.Println(err)
+			}
+		}
+		for _, name := range stale {
+			err := r.propose(command{Op: opReap, Queue: name, Now: now}, nil)
+			if err != nil && err != models.ErrQueueNotFound {
+				fmt.Println(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package queue_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/settings"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/testnode"
+	models "github.com/System-Analysis-and-Design-2023-SUT/Server/models/queue"
+)
+
+func TestDispatchUndelivered(t *testing.T) {
+	var st settings.Settings
+	st.Queue.MaxDeliveries = 1
+	r := testnode.NewRepository(t, &st)
+
+	// checkUndelivered fails unless key waits at the head, never delivered.
+	checkUndelivered := func(key string) {
+		t.Helper()
+		time.Sleep(100 * time.Millisecond)
+		d, err := r.Peek("")
+		if err != nil || d.Key != key || d.Deliveries != 0 {
+			t.Fatalf("expected %q undelivered at the head, got %v %v", key, d, err)
+		}
+		if dead, _ := r.DeadLetter(""); len(dead) != 0 {
+			t.Fatalf("unexpected dead-letter items %v", dead)
+		}
+	}
+
+	// A subscriber whose filter does not pick the item is not handed it.
+	picky := models.NewHTTPConn()
+	defer picky.Close()
+	if _, err := r.Subscribe("", "", picky, "picky", models.Subscription{Filter: models.Filter{KeyPrefix: "x"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Push("", models.Data{Key: "a"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	checkUndelivered("a")
+
+	// Neither is one out of credit once it took what it asked for.
+	c := models.NewHTTPConn()
+	defer c.Close()
+	if _, err := r.Subscribe("", "", c, "c", models.Subscription{Credit: 1}); err != nil {
+		t.Fatal(err)
+	}
+	h, err := c.Next(nil, time.Second)
+	if err != nil || h.Data.Key != "a" {
+		t.Fatalf("expected a, got %v %v", h.Data, err)
+	}
+	h.Written(nil)
+
+	if _, err := r.Push("", models.Data{Key: "b"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	checkUndelivered("b")
+}
@@ -0,0 +1,341 @@
+package queue
+
+import (
+	"encoding/json"
+	"time"
+
+	models "github.com/System-Analysis-and-Design-2023-SUT/Server/models/queue"
+	"github.com/pkg/errors"
+)
+
+const (
+	opCreate  = "create"
+	opDrop    = "drop"
+	opPush    = "push"
+	opBatch   = "batch"
+	opPull    = "pull"
+	opLease   = "lease"
+	opAck     = "ack"
+	opRelease = "release"
+	opReturn  = "return"
+	opExpire  = "expire"
+	opRedrive = "redrive"
+	opPurge   = "purge"
+	opReap    = "reap"
+	opMode    = "mode"
+	opLevel   = "consistency"
+	opRevoke  = "revoke"
+
+	opCheckpoint = "checkpoint"
+
+	opGroupCreate = "group-create"
+	opGroupDrop   = "group-drop"
+	opGroupReset  = "group-reset"
+	opConsume     = "consume"
+	opCommit      = "commit"
+)
+
+// command is a change of the queues committed through the raft log.
+// Anything not deterministic, like receipts, clock readings and local
+// settings, is decided by the proposer so every replica applies the same
+// change.
+type command struct {
+	Op string `json:"op"`
+	// Queue is the name of the queue, empty for the default one.
+	Queue   string      `json:"queue,omitempty"`
+	Data    models.Data `json:"data"`
+	Receipt string      `json:"receipt,omitempty"`
+	// Items, Count and Receipts carry batches of push, pull and lease.
+	Items         []models.Data `json:"items,omitempty"`
+	Count         int           `json:"count,omitempty"`
+	Receipts      []string      `json:"receipts,omitempty"`
+	Time          time.Time     `json:"time,omitempty"`
+	MaxDeliveries int           `json:"maxDeliveries,omitempty"`
+	// Now is the clock of the proposer, items expired by then are dropped.
+	Now time.Time `json:"now,omitempty"`
+	// Window is how long pushed items are remembered to answer retries.
+	Window time.Duration `json:"window,omitempty"`
+	// Retention is how many items the log of the queue keeps.
+	Retention int `json:"retention,omitempty"`
+	// Group, From and Offset address a consumer group and its offset.
+	Group  string `json:"group,omitempty"`
+	From   string `json:"from,omitempty"`
+	Offset uint64 `json:"offset,omitempty"`
+	// Mode is how items reach subscribers of the queue.
+	Mode string `json:"mode,omitempty"`
+	// Consistency is how many replicas confirm a push into the queue.
+	Consistency string `json:"consistency,omitempty"`
+	// Filters limit pulls and leases to the items one of them picks.
+	Filters []models.Filter `json:"filters,omitempty"`
+}
+
+// result is what applying a command produced.
+type result struct {
+	Value json.RawMessage `json:"value,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// modelErrors lets errors returned by the queue survive the round trip
+// through the raft log and between nodes.
+var modelErrors = []error{
+	models.ErrKeyExist,
+	models.ErrEmptyList,
+	models.ErrParseData,
+	models.ErrKeyNotFound,
+	models.ErrObjectNotFound,
+	models.ErrReceiptNotFound,
+	models.ErrInvalidBatch,
+	models.ErrQueueExist,
+	models.ErrQueueNotFound,
+	models.ErrInvalidQueueName,
+	models.ErrDefaultQueue,
+	models.ErrGroupExist,
+	models.ErrGroupNotFound,
+	models.ErrInvalidGroupName,
+	models.ErrInvalidOffset,
+	models.ErrInvalidMode,
+	models.ErrInvalidConsistency,
+}
+
+// state is the snapshot of everything the commands changed.
+type state struct {
+	Queues map[string]json.RawMessage `json:"queues"`
+	// Sequence is the last sequence number handed to a pushed item.
+	Sequence uint64 `json:"sequence"`
+}
+
+// stateMachine applies committed commands to the queues of the repository.
+type stateMachine struct {
+	r *Repository
+}
+
+func (f *stateMachine) Apply(b []byte) []byte {
+	var cmd command
+	if err := json.Unmarshal(b, &cmd); err != nil {
+		return encodeResult(nil, models.ErrParseData)
+	}
+
+	v, err := f.r.apply(cmd)
+	return encodeResult(v, err)
+}
+
+func (f *stateMachine) Snapshot() ([]byte, error) {
+	f.r.mu.Lock()
+	defer f.r.mu.Unlock()
+
+	s := state{Queues: make(map[string]json.RawMessage, len(f.r.queues)), Sequence: f.r.sequence}
+	for name, q := range f.r.queues {
+		b, err := json.Marshal(q.Clone())
+		if err != nil {
+			return nil, err
+		}
+		s.Queues[name] = b
+	}
+	return json.Marshal(s)
+}
+
+func (f *stateMachine) Restore(snapshot []byte) error {
+	var s state
+	if err := json.Unmarshal(snapshot, &s); err != nil {
+		return models.ErrParseData
+	}
+	// Snapshots taken before named queues hold the default queue alone.
+	if s.Queues == nil {
+		s.Queues = map[string]json.RawMessage{models.DefaultQueue: snapshot}
+	}
+
+	queues := make(map[string]*models.Queue, len(s.Queues))
+	for name, b := range s.Queues {
+		q := models.NewQueue()
+		if err := q.BulkPush(b); err != nil {
+			return err
+		}
+		queues[name] = q
+		s.Sequence = max(s.Sequence, q.Sequence())
+	}
+	if _, ok := queues[models.DefaultQueue]; !ok {
+		queues[models.DefaultQueue] = models.NewQueue()
+	}
+
+	f.r.mu.Lock()
+	defer f.r.mu.Unlock()
+	f.r.queues = queues
+	f.r.sequence = s.Sequence
+	// Checkpoints recorded before do not describe the restored queues.
+	f.r.checkpoints = make(map[string]checkpoint)
+	f.r.checkpointOrder = nil
+	f.r.syncSubscribers()
+	return nil
+}
+
+// apply runs a committed command against the local queues.
+func (r *Repository) apply(cmd command) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := queueName(cmd.Queue)
+	switch cmd.Op {
+	case opCreate:
+		if _, ok := r.queues[name]; ok {
+			return nil, models.ErrQueueExist
+		}
+		q := models.NewQueue()
+		if err := q.SetMode(cmd.Mode); err != nil {
+			return nil, err
+		}
+		r.queues[name] = q
+		r.syncSubscribers()
+		return name, nil
+	case opDrop:
+		if name == models.DefaultQueue {
+			return nil, models.ErrDefaultQueue
+		}
+		if _, ok := r.queues[name]; !ok {
+			return nil, models.ErrQueueNotFound
+		}
+		delete(r.queues, name)
+		r.syncSubscribers()
+		return name, nil
+	case opCheckpoint:
+		return r.record(cmd.Receipt)
+	}
+
+	q, err := r.queue(name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cmd.Op {
+	case opPush:
+		cmd.Data.Sequence = r.nextSequence(1)
+		d, err := q.PushOnce(cmd.Data, cmd.Now, cmd.Window)
+		if err != nil {
+			return nil, err
+		}
+		q.Trim(cmd.Retention)
+		r.notify(name)
+		return d, nil
+	case opBatch:
+		first := r.nextSequence(len(cmd.Items))
+		for i := range cmd.Items {
+			cmd.Items[i].Sequence = first + uint64(i)
+		}
+		results := q.PushBatch(cmd.Items, cmd.Now, cmd.Window)
+		q.Trim(cmd.Retention)
+		r.notify(name)
+		return results, nil
+	case opPull:
+		if cmd.Count > 0 {
+			return q.PullBatch(cmd.Count, cmd.Now, cmd.Filters...), nil
+		}
+		return q.Pull(cmd.Now, cmd.Filters...)
+	case opLease:
+		if len(cmd.Receipts) > 0 {
+			return q.LeaseBatch(cmd.Receipts, cmd.Now, cmd.Time, cmd.Filters...), nil
+		}
+		return q.Lease(cmd.Receipt, cmd.Now, cmd.Time, cmd.Filters...)
+	case opAck:
+		return q.Ack(cmd.Receipt)
+	case opRelease:
+		d, err := q.Release(cmd.Receipt, cmd.MaxDeliveries)
+		if err == nil {
+			r.notify(name)
+		}
+		return d, err
+	case opReturn:
+		d, err := q.Return(cmd.Receipt)
+		if err == nil {
+			r.notify(name)
+		}
+		return d, err
+	case opExpire:
+		expired := q.Expire(cmd.Time, cmd.MaxDeliveries)
+		if len(expired) > 0 {
+			r.notify(name)
+		}
+		return expired, nil
+	case opRedrive:
+		d, err := q.Redrive(cmd.Data.Key)
+		if err == nil {
+			r.notify(name)
+		}
+		return d, err
+	case opPurge:
+		return q.Purge(cmd.Data.Key)
+	case opReap:
+		return q.Reap(cmd.Now), nil
+	case opMode:
+		if err := q.SetMode(cmd.Mode); err != nil {
+			return nil, err
+		}
+		r.syncSubscribers()
+		return q.Mode(), nil
+	case opLevel:
+		if err := q.SetConsistency(cmd.Consistency); err != nil {
+			return nil, err
+		}
+		return q.Consistency(), nil
+	case opRevoke:
+		return q.Revoke(cmd.Items), nil
+	case opGroupCreate:
+		return q.CreateGroup(cmd.Group, cmd.From)
+	case opGroupDrop:
+		err := q.DeleteGroup(cmd.Group)
+		if err == nil {
+			r.syncSubscribers()
+		}
+		return cmd.Group, err
+	case opGroupReset:
+		g, err := q.ResetGroup(cmd.Group, cmd.From, cmd.Offset)
+		if err == nil {
+			r.notify(name)
+		}
+		return g, err
+	case opConsume:
+		return q.Consume(cmd.Group, cmd.Count)
+	case opCommit:
+		return q.Commit(cmd.Group, cmd.Offset)
+	}
+	return nil, models.ErrParseData
+}
+
+// nextSequence reserves n sequence numbers for pushed items and returns the
+// first one. Commands are applied in the order of the raft log on every
+// replica, so every replica hands out the same numbers. Numbers of items
+// that are rejected or retries are skipped. Callers should hold r.mu.
+func (r *Repository) nextSequence(n int) uint64 {
+	first := r.sequence + 1
+	r.sequence += uint64(n)
+	return first
+}
+
+func encodeResult(v interface{}, err error) []byte {
+	var res result
+	if err != nil {
+		res.Error = err.Error()
+	} else {
+		res.Value, _ = json.Marshal(v)
+	}
+	b, _ := json.Marshal(res)
+	return b
+}
+
+// decodeResult unmarshals value of the result into v.
+func decodeResult(b []byte, v interface{}) error {
+	var res result
+	if err := json.Unmarshal(b, &res); err != nil {
+		return err
+	}
+	if res.Error == "" {
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(res.Value, v)
+	}
+	for _, err := range modelErrors {
+		if err.Error() == res.Error {
+			return err
+		}
+	}
+	return errors.New(res.Error)
+}
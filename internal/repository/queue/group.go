@@ -0,0 +1,51 @@
+package queue
+
+import models "github.com/System-Analysis-and-Design-2023-SUT/Server/models/queue"
+
+// Groups returns consumer groups of the queue.
+func (r *Repository) Groups(name string) ([]models.Group, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	q, err := r.queue(name)
+	if err != nil {
+		return nil, err
+	}
+	return q.Groups(), nil
+}
+
+// CreateGroup adds a consumer group reading the queue from the earliest
+// or latest item, the latest if from is empty.
+func (r *Repository) CreateGroup(name string, group string, from string) (models.Group, error) {
+	if !models.ValidQueueName(group) {
+		return models.Group{}, models.ErrInvalidGroupName
+	}
+
+	var g models.Group
+	err := r.propose(command{Op: opGroupCreate, Queue: name, Group: group, From: from}, &g)
+	return g, err
+}
+
+func (r *Repository) DeleteGroup(name string, group string) error {
+	return r.propose(command{Op: opGroupDrop, Queue: name, Group: group}, nil)
+}
+
+// ResetGroup moves a consumer group to offset, or to the earliest or
+// latest item if from says so.
+func (r *Repository) ResetGroup(name string, group string, from string, offset uint64) (models.Group, error) {
+	var g models.Group
+	err := r.propose(command{Op: opGroupReset, Queue: name, Group: group, From: from, Offset: offset}, &g)
+	return g, err
+}
+
+// Consume returns up to count items the consumer group did not read yet
+// and commits them.
+func (r *Repository) Consume(name string, group string, count int) ([]models.Record, error) {
+	if count <= 0 || count > r.st.Queue.MaxBatch {
+		return nil, models.ErrInvalidBatch
+	}
+
+	var records []models.Record
+	err := r.propose(command{Op: opConsume, Queue: name, Group: group, Count: count}, &records)
+	return records, err
+}
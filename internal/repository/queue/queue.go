@@ -1,115 +1,558 @@
 package queue
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/helper"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/raft"
 	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/settings"
 	models "github.com/System-Analysis-and-Design-2023-SUT/Server/models/queue"
-	"github.com/gorilla/websocket"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/pkg/wal"
 	"github.com/pkg/errors"
 )
 
 type Repository struct {
-	st         *settings.Settings
-	helper     *helper.Helper
-	queue      *models.Queue
-	subscriber *models.Subscriber
+	// mu guards the queues against the raft apply loop.
+	mu          sync.Mutex
+	st          *settings.Settings
+	helper      *helper.Helper
+	node        *raft.Node
+	queues      map[string]*models.Queue
+	subscribers map[string]*models.Subscriber
+	// groupSubscribers holds subscribers of consumer groups by queue and
+	// group.
+	groupSubscribers map[string]map[string]*models.Subscriber
+	// busyGroups holds consumer groups waiting for an item to be written.
+	busyGroups map[string]bool
+
+	// sequence is the last sequence number handed to a pushed item, guarded
+	// by mu.
+	sequence uint64
+
+	// pending holds queues that may have items for their subscribers.
+	pending  map[string]struct{}
+	dispatch chan struct{}
+
+	// checkpoints holds the last checkpoints applied, oldest first in
+	// checkpointOrder. They are guarded by mu.
+	checkpoints     map[string]checkpoint
+	checkpointOrder []string
+	// reconcileMu runs one anti-entropy round at a time and reportMu
+	// guards their outcome.
+	reconcileMu sync.Mutex
+	reportMu    sync.Mutex
+	report      models.Reconciliation
 }
 
-func NewRepository(st *settings.Settings, helper *helper.Helper, q *models.Queue, s *models.Subscriber) (*Repository, error) {
+func NewRepository(st *settings.Settings, helper *helper.Helper, w *wal.Log) (*Repository, error) {
 	if st == nil {
 		return nil, errors.New("st should not be nil")
 	}
 	if helper == nil {
 		return nil, errors.New("helper should not be nil")
 	}
-	if q == nil {
-		return nil, errors.New("queue should not be nil")
+	if w == nil {
+		return nil, errors.New("wal should not be nil")
 	}
-	if s == nil {
-		return nil, errors.New("subscriber should not be nil")
+
+	r := &Repository{
+		st:               st,
+		helper:           helper,
+		queues:           map[string]*models.Queue{models.DefaultQueue: models.NewQueue()},
+		subscribers:      make(map[string]*models.Subscriber),
+		groupSubscribers: make(map[string]map[string]*models.Subscriber),
+		busyGroups:       make(map[string]bool),
+		pending:          make(map[string]struct{}),
+		dispatch:         make(chan struct{}, 1),
+		checkpoints:      make(map[string]checkpoint),
 	}
+	r.syncSubscribers()
 
-	d, err := helper.GetQueue()
+	// The raft log replays whatever was committed before a restart and
+	// catches up with the leader afterwards.
+	node, err := raft.NewNode(raft.Config{
+		ID:                helper.LocalID(),
+		ClusterSize:       st.Replica.MemberCount,
+		ElectionTimeout:   st.Consensus.ElectionTimeout,
+		HeartbeatInterval: st.Consensus.HeartbeatInterval,
+		ProposeTimeout:    st.Consensus.ProposeTimeout,
+		SnapshotInterval:  st.Storage.CompactInterval,
+	}, &stateMachine{r: r}, helper, w)
 	if err != nil {
-		fmt.Println(err)
-	} else {
-		fmt.Println("Get queue")
-		err := q.BulkPush(d)
-		if err != nil {
-			return &Repository{}, err
-		}
+		return nil, errors.Wrap(err, "could not initialize raft node")
+	}
+	r.node = node
+
+	// The leader compares the other replicas with itself through these.
+	helper.Handle(methodDigest, r.handleDigest)
+	helper.Handle(methodBucket, r.handleBucket)
+
+	node.Start()
+	go r.dispatchLoop()
+	go r.expireLoop()
+	if st.Replica.ReconcileInterval > 0 {
+		go r.reconcileLoop()
 	}
 
-	return &Repository{
-		st:         st,
-		helper:     helper,
-		queue:      q,
-		subscriber: s,
-	}, nil
+	return r, nil
 }
 
-// Push will save data into queue
-func (r *Repository) Push(data models.Data, force bool) (models.Data, error) {
-	if len(r.subscriber.List) > 0 {
-		time.Sleep(1 * time.Second)
+// Push will save data into queue once as many replicas as level, or the
+// consistency level of the queue if empty, confirmed it. A retry within the
+// deduplication window returns the item pushed first. The item returned
+// carries its sequence number, except with ConsistencyOne which returns
+// before the push is applied: it hands back data as sent, without a
+// sequence number, even to a retry of an item pushed before.
+func (r *Repository) Push(name string, data models.Data, level string) (models.Data, error) {
+	now := time.Now()
+	data.EnqueuedAt = now
 
-		err := r.helper.Read(data)
-		if err != nil {
-			return models.Data{}, err
-		}
+	var d models.Data
+	applied, err := r.write(command{Op: opPush, Queue: name, Data: data, Now: now, Window: r.st.Queue.DedupWindow, Retention: r.st.Queue.Retention}, level, &d)
+	if err == nil && !applied {
+		d = data
+	}
+	return d, err
+}
+
+// PushBatch saves items into queue through a single raft entry and
+// reports the outcome of each one. Like Push it waits for level.
+func (r *Repository) PushBatch(name string, items []models.Data, level string) ([]models.PushResult, error) {
+	if len(items) == 0 || len(items) > r.st.Queue.MaxBatch {
+		return nil, models.ErrInvalidBatch
+	}
+	now := time.Now()
+	for i := range items {
+		items[i].EnqueuedAt = now
+	}
 
-		err = r.subscriber.Send(data)
-		if err == nil {
-			return data, err
+	var results []models.PushResult
+	applied, err := r.write(command{Op: opBatch, Queue: name, Items: items, Now: now, Window: r.st.Queue.DedupWindow, Retention: r.st.Queue.Retention}, level, &results)
+	if err == nil && !applied {
+		// Duplicates are not known before the batch is applied.
+		results = make([]models.PushResult, len(items))
+		for i := range items {
+			results[i].Key = items[i].Key
 		}
 	}
+	return results, err
+}
+
+// Pull return head of queue, or the first item one of filters picks.
+func (r *Repository) Pull(name string, filters ...models.Filter) (models.Data, error) {
+	var d models.Data
+	err := r.propose(command{Op: opPull, Queue: name, Now: time.Now(), Filters: filters}, &d)
+	return d, err
+}
+
+// PullBatch returns up to count items from head of queue, or the ones one
+// of filters picks.
+func (r *Repository) PullBatch(name string, count int, filters ...models.Filter) ([]models.Data, error) {
+	if count <= 0 || count > r.st.Queue.MaxBatch {
+		return nil, models.ErrInvalidBatch
+	}
 
-	err := r.queue.Push(data)
+	var d []models.Data
+	err := r.propose(command{Op: opPull, Queue: name, Count: count, Now: time.Now(), Filters: filters}, &d)
+	return d, err
+}
+
+// Peek returns head of queue without removing it. It reads the local
+// replica, so a follower may lag behind the leader.
+func (r *Repository) Peek(name string) (models.Data, error) {
+	r.mu.Lock()
+	q, err := r.queue(name)
+	r.mu.Unlock()
 	if err != nil {
 		return models.Data{}, err
 	}
-	if !force {
-		err = r.helper.Write(data)
+	return q.Peek(time.Now())
+}
+
+// Lease returns head of queue, or the first item one of filters picks, and
+// hides it from other consumers until it is acked or visibility passes.
+// Zero visibility means the default one.
+func (r *Repository) Lease(name string, visibility time.Duration, filters ...models.Filter) (models.Lease, error) {
+	if visibility <= 0 {
+		visibility = r.st.Queue.VisibilityTimeout
+	}
+
+	receipt, err := newReceipt()
+	if err != nil {
+		return models.Lease{}, err
+	}
+
+	now := time.Now()
+	var l models.Lease
+	err = r.propose(command{Op: opLease, Queue: name, Receipt: receipt, Now: now, Time: now.Add(visibility), Filters: filters}, &l)
+	return l, err
+}
+
+// LeaseBatch leases up to count items from head of queue, or items one of
+// filters picks, each under its own receipt.
+func (r *Repository) LeaseBatch(name string, visibility time.Duration, count int, filters ...models.Filter) ([]models.Lease, error) {
+	if count <= 0 || count > r.st.Queue.MaxBatch {
+		return nil, models.ErrInvalidBatch
+	}
+	if visibility <= 0 {
+		visibility = r.st.Queue.VisibilityTimeout
+	}
+
+	receipts := make([]string, count)
+	for i := range receipts {
+		var err error
+		receipts[i], err = newReceipt()
 		if err != nil {
-			return models.Data{}, err
+			return nil, err
 		}
 	}
-	return data, nil
+
+	now := time.Now()
+	var l []models.Lease
+	err := r.propose(command{Op: opLease, Queue: name, Receipts: receipts, Now: now, Time: now.Add(visibility), Filters: filters}, &l)
+	return l, err
+}
+
+// Ack removes a leased item from the queue for good.
+func (r *Repository) Ack(name string, receipt string) (models.Data, error) {
+	var d models.Data
+	err := r.propose(command{Op: opAck, Queue: name, Receipt: receipt}, &d)
+	return d, err
+}
+
+// Release gives a leased item back to the queue before its lease expires.
+func (r *Repository) Release(name string, receipt string) (models.Data, error) {
+	var d models.Data
+	err := r.propose(command{Op: opRelease, Queue: name, Receipt: receipt, MaxDeliveries: r.st.Queue.MaxDeliveries}, &d)
+	return d, err
+}
+
+// Return gives a leased item back to the queue without counting it as
+// delivered.
+func (r *Repository) Return(name string, receipt string) (models.Data, error) {
+	var d models.Data
+	err := r.propose(command{Op: opReturn, Queue: name, Receipt: receipt}, &d)
+	return d, err
+}
+
+// DeadLetter returns items that ran out of delivery attempts.
+func (r *Repository) DeadLetter(name string) ([]models.Data, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	q, err := r.queue(name)
+	if err != nil {
+		return nil, err
+	}
+	return q.Clone().DeadLetter, nil
+}
+
+// Redrive moves dead-letter items back to the queue. Empty key moves all.
+func (r *Repository) Redrive(name string, key string) ([]models.Data, error) {
+	var d []models.Data
+	err := r.propose(command{Op: opRedrive, Queue: name, Data: models.Data{Key: key}}, &d)
+	return d, err
+}
+
+// Purge drops dead-letter items. Empty key drops all.
+func (r *Repository) Purge(name string, key string) ([]models.Data, error) {
+	var d []models.Data
+	err := r.propose(command{Op: opPurge, Queue: name, Data: models.Data{Key: key}}, &d)
+	return d, err
+}
+
+// Queues returns names of every queue.
+func (r *Repository) Queues() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.queues))
+	for name := range r.queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CreateQueue adds a queue delivering items to subscribers in mode, at
+// random if it is empty.
+func (r *Repository) CreateQueue(name string, mode string) error {
+	if !models.ValidQueueName(name) {
+		return models.ErrInvalidQueueName
+	}
+	if !models.ValidMode(mode) {
+		return models.ErrInvalidMode
+	}
+	return r.propose(command{Op: opCreate, Queue: name, Mode: mode}, nil)
+}
+
+// SetMode changes how items reach subscribers of the queue.
+func (r *Repository) SetMode(name string, mode string) (string, error) {
+	if !models.ValidMode(mode) {
+		return "", models.ErrInvalidMode
+	}
+
+	var m string
+	err := r.propose(command{Op: opMode, Queue: name, Mode: mode}, &m)
+	return m, err
 }
 
-// Pull return head of queue
-func (r *Repository) Pull(key string) (models.Data, error) {
-	if key != "" {
-		return models.Data{}, r.queue.Delete(key)
+// SetConsistency changes how many replicas confirm a push into queue, empty
+// for the default level.
+func (r *Repository) SetConsistency(name string, level string) (string, error) {
+	if !models.ValidConsistency(level) {
+		return "", models.ErrInvalidConsistency
 	}
-	d, err := r.queue.Pull()
+
+	var l string
+	err := r.propose(command{Op: opLevel, Queue: name, Consistency: level}, &l)
+	return l, err
+}
+
+func (r *Repository) DeleteQueue(name string) error {
+	if queueName(name) == models.DefaultQueue {
+		return models.ErrDefaultQueue
+	}
+	return r.propose(command{Op: opDrop, Queue: name}, nil)
+}
+
+// Subscribe connects c to the queue, or to its consumer group if group is
+// not empty.
+func (r *Repository) Subscribe(name string, group string, c *models.Conn, addr string, sub models.Subscription) (string, error) {
+	r.mu.Lock()
+	s, err := r.subscriber(name, group)
+	r.mu.Unlock()
 	if err != nil {
-		return models.Data{}, err
+		return "", err
 	}
 
-	err = r.helper.Read(d)
+	resp, err := s.Subscribe(c, addr, sub)
 	if err != nil {
-		return models.Data{}, err
+		return "", err
+	}
+
+	r.mu.Lock()
+	r.notify(queueName(name))
+	r.mu.Unlock()
+	return resp, nil
+}
+
+func (r *Repository) Unsubscribe(name string, group string, addr string) error {
+	r.mu.Lock()
+	var s *models.Subscriber
+	if group == "" {
+		s = r.subscribers[queueName(name)]
+	} else {
+		s = r.groupSubscribers[queueName(name)][group]
+	}
+	r.mu.Unlock()
+	if s == nil {
+		return models.ErrNotSubscribed
+	}
+	return s.Unsubscribe(addr)
+}
+
+// Listen subscribes a connection read by the caller, for consumers over
+// plain HTTP. Items reach it like any other subscriber, so an item goes to
+// one consumer whatever they are connected with. stop unsubscribes it and
+// gives back the items it was handed and the caller did not take.
+func (r *Repository) Listen(name string, group string, sub models.Subscription) (*models.Conn, func(), error) {
+	addr, err := newReceipt()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := models.NewHTTPConn()
+	if _, err := r.Subscribe(name, group, c, addr, sub); err != nil {
+		return nil, nil, err
 	}
-	return d, nil
+
+	stop := func() {
+		if err := r.Unsubscribe(name, group, addr); err != nil && err != models.ErrNotSubscribed {
+			fmt.Println(err)
+		}
+		c.Close()
+	}
+	return c, stop, nil
 }
 
-func (r *Repository) Subscribe(c *websocket.Conn, addr string) string {
-	resp, err := r.subscriber.Subscribe(c, addr)
+// Settle acks the item id written to the subscriber at addr, or gives it
+// back if nack is set.
+func (r *Repository) Settle(name string, group string, addr string, id string, nack bool) error {
+	r.mu.Lock()
+	s, err := r.subscriber(name, group)
+	r.mu.Unlock()
 	if err != nil {
-		return err.Error()
+		return err
+	}
+
+	if nack {
+		return s.Settle(addr, id, models.ErrRejected)
 	}
-	return resp
+	return s.Settle(addr, id, nil)
 }
 
-func (r *Repository) Unsubscribe(c *websocket.Conn, addr string) error {
-	return r.subscriber.Unsubscribe(addr)
+// Credit lets the subscriber at addr be handed n more items.
+func (r *Repository) Credit(name string, group string, addr string, n int) error {
+	r.mu.Lock()
+	s, err := r.subscriber(name, group)
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = s.Credit(addr, n)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.notify(queueName(name))
+	r.mu.Unlock()
+	return nil
 }
 
 // Copy return whole of queue
-func (r *Repository) Copy() *models.Queue {
-	return r.queue
+func (r *Repository) Copy(name string) (*models.Queue, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	q, err := r.queue(name)
+	if err != nil {
+		return nil, err
+	}
+	return q.Clone(), nil
+}
+
+// MaxBatch returns how many items a single request may push or pull.
+func (r *Repository) MaxBatch() int {
+	return r.st.Queue.MaxBatch
+}
+
+// Raft returns the consensus node the queues are replicated with.
+func (r *Repository) Raft() *raft.Node {
+	return r.node
+}
+
+// IsLeader reports whether this node serves the subscribers.
+func (r *Repository) IsLeader() bool {
+	return r.node.IsLeader()
+}
+
+// Leader returns address of the leader node.
+func (r *Repository) Leader() (string, error) {
+	p, ok := r.node.Leader()
+	if !ok {
+		return "", raft.ErrNoLeader
+	}
+	return p.Address, nil
+}
+
+// propose commits cmd and unmarshals its result into v.
+func (r *Repository) propose(cmd command, v interface{}) error {
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.node.Propose(b, raft.ConsistencyQuorum)
+	if err != nil {
+		return err
+	}
+	return decodeResult(resp, v)
+}
+
+// queue returns the queue called name. Callers should hold r.mu.
+func (r *Repository) queue(name string) (*models.Queue, error) {
+	q, ok := r.queues[queueName(name)]
+	if !ok {
+		return nil, models.ErrQueueNotFound
+	}
+	return q, nil
+}
+
+// subscriber returns subscribers of the queue, or of its consumer group if
+// group is not empty. Callers should hold r.mu.
+func (r *Repository) subscriber(name string, group string) (*models.Subscriber, error) {
+	name = queueName(name)
+	q, err := r.queue(name)
+	if err != nil {
+		return nil, err
+	}
+	if group == "" {
+		return r.subscribers[name], nil
+	}
+	if !q.HasGroup(group) {
+		return nil, models.ErrGroupNotFound
+	}
+
+	groups, ok := r.groupSubscribers[name]
+	if !ok {
+		groups = make(map[string]*models.Subscriber)
+		r.groupSubscribers[name] = groups
+	}
+	s, ok := groups[group]
+	if !ok {
+		s = models.NewSubscriber()
+		s.SetDone(r.groupDone(name, group))
+		groups[group] = s
+	}
+	return s, nil
+}
+
+// syncSubscribers gives every queue its subscribers and disconnects the
+// subscribers of dropped queues and groups. Callers should hold r.mu.
+//
+// Disconnecting reports undelivered items to the done handlers, which
+// take r.mu and propose through raft, so it is left to a goroutine rather
+// than run inside apply.
+func (r *Repository) syncSubscribers() {
+	for name, s := range r.subscribers {
+		if _, ok := r.queues[name]; !ok {
+			go s.Close()
+			delete(r.subscribers, name)
+		}
+	}
+	for name, groups := range r.groupSubscribers {
+		q, ok := r.queues[name]
+		for group, s := range groups {
+			if !ok || !q.HasGroup(group) {
+				go s.Close()
+				delete(groups, group)
+				delete(r.busyGroups, groupKey(name, group))
+			}
+		}
+		if len(groups) == 0 {
+			delete(r.groupSubscribers, name)
+		}
+	}
+	for name, q := range r.queues {
+		if _, ok := r.subscribers[name]; !ok {
+			r.subscribers[name] = models.NewSubscriber()
+			r.subscribers[name].SetDone(r.queueDone(name))
+		}
+		r.subscribers[name].SetMode(q.Mode())
+	}
+}
+
+// queueName maps the empty name to the default queue.
+func queueName(name string) string {
+	if name == "" {
+		return models.DefaultQueue
+	}
+	return name
+}
+
+func newReceipt() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
@@ -0,0 +1,291 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/raft"
+	models "github.com/System-Analysis-and-Design-2023-SUT/Server/models/queue"
+)
+
+const (
+	methodDigest = "queue.digest"
+	methodBucket = "queue.bucket"
+
+	// keptCheckpoints is how many checkpoints a replica remembers, so a
+	// round still finds its own after a few concurrent ones.
+	keptCheckpoints = 4
+)
+
+// checkpoint holds the digests of the queues when a checkpoint command was
+// applied. Every replica applies it at the same index of the raft log, so
+// replicas in sync hold the same checkpoint. Hashes of single items are
+// not kept, they are computed when a round looks into a bucket.
+type checkpoint map[string]models.Digest
+
+type digestRequest struct {
+	Checkpoint string `json:"checkpoint"`
+}
+
+type bucketRequest struct {
+	Checkpoint string `json:"checkpoint"`
+	Queue      string `json:"queue"`
+	Buckets    []int  `json:"buckets"`
+}
+
+// record computes the checkpoint id of the queues. Callers should hold
+// r.mu.
+func (r *Repository) record(id string) (map[string]models.Digest, error) {
+	cp := make(checkpoint, len(r.queues))
+	for name, q := range r.queues {
+		d, err := q.Digest()
+		if err != nil {
+			return nil, err
+		}
+		cp[name] = d
+	}
+
+	if _, ok := r.checkpoints[id]; !ok {
+		r.checkpointOrder = append(r.checkpointOrder, id)
+	}
+	r.checkpoints[id] = cp
+	for len(r.checkpointOrder) > keptCheckpoints {
+		delete(r.checkpoints, r.checkpointOrder[0])
+		r.checkpointOrder = r.checkpointOrder[1:]
+	}
+	return cp, nil
+}
+
+// Reconciliation returns the outcome of the anti-entropy rounds this node
+// ran while leading.
+func (r *Repository) Reconciliation() models.Reconciliation {
+	r.reportMu.Lock()
+	defer r.reportMu.Unlock()
+
+	rec := r.report
+	rec.Replicas = make(map[string]models.ReplicaReport, len(r.report.Replicas))
+	for id, rep := range r.report.Replicas {
+		rec.Replicas[id] = rep
+	}
+	return rec
+}
+
+// Reconcile compares every replica with the leader and repairs the ones
+// that diverged by installing a snapshot of the leader on them. It runs on
+// the leader only.
+func (r *Repository) Reconcile() (models.Reconciliation, error) {
+	r.reconcileMu.Lock()
+	defer r.reconcileMu.Unlock()
+
+	if !r.node.IsLeader() {
+		return models.Reconciliation{}, raft.ErrNotLeader
+	}
+
+	id, err := newReceipt()
+	if err != nil {
+		return models.Reconciliation{}, err
+	}
+	var digests map[string]models.Digest
+	if err := r.propose(command{Op: opCheckpoint, Receipt: id}, &digests); err != nil {
+		return models.Reconciliation{}, err
+	}
+
+	r.mu.Lock()
+	local, ok := r.checkpoints[id]
+	r.mu.Unlock()
+	if !ok {
+		return models.Reconciliation{}, models.ErrCheckpointNotFound
+	}
+
+	replicas := make(map[string]models.ReplicaReport)
+	for _, p := range r.helper.Peers() {
+		rep := r.compare(p, id, local)
+		if rep.Diverged {
+			if err := r.node.Repair(p.ID); err != nil {
+				rep.Error = err.Error()
+			} else {
+				rep.Repaired = true
+			}
+		}
+		if rep.Error != "" {
+			fmt.Println("Could not reconcile replica", p.ID, rep.Error)
+		}
+		replicas[p.ID] = rep
+	}
+
+	r.reportMu.Lock()
+	r.report.Rounds++
+	r.report.LastRound = time.Now()
+	r.report.Replicas = replicas
+	for _, rep := range replicas {
+		if rep.Diverged {
+			r.report.Divergences++
+		}
+		if rep.Repaired {
+			r.report.Repairs++
+		}
+		if rep.Error != "" {
+			r.report.Failures++
+		}
+	}
+	r.reportMu.Unlock()
+
+	return r.Reconciliation(), nil
+}
+
+// compare finds how the checkpoint id of peer differs from local. Whether
+// a queue differs is known from the checkpoint, which items differ is
+// found from the items both hold by then, so writes meanwhile may be
+// counted too.
+func (r *Repository) compare(p raft.Peer, id string, local checkpoint) models.ReplicaReport {
+	var rep models.ReplicaReport
+
+	remote, err := r.remoteDigests(p, id)
+	if err != nil {
+		rep.Error = err.Error()
+		return rep
+	}
+
+	names := make(map[string]struct{}, len(local)+len(remote))
+	for name := range local {
+		names[name] = struct{}{}
+	}
+	for name := range remote {
+		names[name] = struct{}{}
+	}
+
+	for name := range names {
+		ld, inLocal := local[name]
+		rd, inRemote := remote[name]
+		if inLocal && inRemote && ld.Root == rd.Root {
+			continue
+		}
+		rep.Diverged = true
+		rep.Queues = append(rep.Queues, name)
+
+		switch {
+		case !inRemote:
+			rep.Missing += ld.Items
+			continue
+		case !inLocal:
+			rep.Extra += rd.Items
+			continue
+		}
+
+		buckets := ld.Diff(rd)
+		if len(buckets) == 0 {
+			continue
+		}
+		var items map[string]string
+		err := r.helper.Call(p, methodBucket, &bucketRequest{Checkpoint: id, Queue: name, Buckets: buckets}, &items)
+		if err != nil {
+			rep.Error = err.Error()
+			continue
+		}
+		localItems, err := r.bucketItems(name, buckets)
+		if err != nil {
+			rep.Error = err.Error()
+			continue
+		}
+		for k, h := range localItems {
+			rh, ok := items[k]
+			if !ok {
+				rep.Missing++
+			} else if rh != h {
+				rep.Stale++
+			}
+		}
+		for k := range items {
+			if _, ok := localItems[k]; !ok {
+				rep.Extra++
+			}
+		}
+	}
+	sort.Strings(rep.Queues)
+	return rep
+}
+
+// remoteDigests returns the checkpoint id of peer, waiting for peer to
+// apply it for as long as a command may take to commit.
+func (r *Repository) remoteDigests(p raft.Peer, id string) (map[string]models.Digest, error) {
+	deadline := time.Now().Add(r.st.Consensus.ProposeTimeout)
+	for {
+		var digests map[string]models.Digest
+		err := r.helper.Call(p, methodDigest, &digestRequest{Checkpoint: id}, &digests)
+		if err == nil {
+			return digests, nil
+		}
+		if err.Error() != models.ErrCheckpointNotFound.Error() || time.Now().After(deadline) {
+			return nil, err
+		}
+		time.Sleep(r.st.Consensus.HeartbeatInterval)
+	}
+}
+
+// handleDigest answers the leader with the digests of a checkpoint.
+func (r *Repository) handleDigest(body []byte) ([]byte, error) {
+	var req digestRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	cp, ok := r.checkpoints[req.Checkpoint]
+	r.mu.Unlock()
+	if !ok {
+		return nil, models.ErrCheckpointNotFound
+	}
+	return json.Marshal(cp)
+}
+
+// bucketItems returns the hash of every item of queue name in buckets, none
+// if the queue is gone.
+func (r *Repository) bucketItems(name string, buckets []int) (map[string]string, error) {
+	r.mu.Lock()
+	q, ok := r.queues[name]
+	r.mu.Unlock()
+	if !ok {
+		return map[string]string{}, nil
+	}
+	return q.BucketItems(buckets)
+}
+
+// handleBucket answers the leader with the hash of every item of a queue
+// in the buckets it asks for, as the queue is by now.
+func (r *Repository) handleBucket(body []byte) ([]byte, error) {
+	var req bucketRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	_, ok := r.checkpoints[req.Checkpoint]
+	r.mu.Unlock()
+	if !ok {
+		return nil, models.ErrCheckpointNotFound
+	}
+
+	items, err := r.bucketItems(req.Queue, req.Buckets)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(items)
+}
+
+// reconcileLoop runs an anti-entropy round every interval while this node
+// leads the cluster.
+func (r *Repository) reconcileLoop() {
+	ticker := time.NewTicker(r.st.Replica.ReconcileInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !r.node.IsLeader() {
+			continue
+		}
+		if _, err := r.Reconcile(); err != nil && err != raft.ErrNotLeader {
+			fmt.Println("Could not reconcile replicas", err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package queue
+
+import (
+	"net/http"
+	"strconv"
+
+	models "github.com/System-Analysis-and-Design-2023-SUT/Server/models/queue"
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Service) Groups(c *gin.Context) {
+	resp, err := s.repo.Groups(c.Param("name"))
+	if err != nil {
+		c.JSON(status(err), err.Error())
+	} else {
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (s *Service) CreateGroup(c *gin.Context) {
+	resp, err := s.repo.CreateGroup(c.Param("name"), c.Param("group"), c.Query("from"))
+	if err != nil {
+		c.JSON(status(err), err.Error())
+	} else {
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (s *Service) DeleteGroup(c *gin.Context) {
+	group := c.Param("group")
+
+	err := s.repo.DeleteGroup(c.Param("name"), group)
+	if err != nil {
+		c.JSON(status(err), err.Error())
+	} else {
+		c.JSON(http.StatusOK, group)
+	}
+}
+
+// ResetGroup moves a consumer group to the offset in the query, or to the
+// earliest or latest item if from says so.
+func (s *Service) ResetGroup(c *gin.Context) {
+	from := c.Query("from")
+
+	var offset uint64
+	if v := c.Query("offset"); from == "" {
+		var err error
+		offset, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrInvalidOffset.Error())
+			return
+		}
+	}
+
+	resp, err := s.repo.ResetGroup(c.Param("name"), c.Param("group"), from, offset)
+	if err != nil {
+		c.JSON(status(err), err.Error())
+	} else {
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// Consume returns items the consumer group did not read yet, one unless
+// the query has a count.
+func (s *Service) Consume(c *gin.Context) {
+	count := 1
+	if v := c.Query("count"); v != "" {
+		var err error
+		count, err = strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrInvalidBatch.Error())
+			return
+		}
+	}
+
+	resp, err := s.repo.Consume(c.Param("name"), c.Param("group"), count)
+	if err != nil {
+		c.JSON(status(err), err.Error())
+	} else {
+		c.JSON(http.StatusOK, resp)
+	}
+}
@@ -1,13 +1,17 @@
 package queue
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/repository/queue"
 	models "github.com/System-Analysis-and-Design-2023-SUT/Server/models/queue"
 	"github.com/gin-gonic/gin"
-	"github.com/gorilla/websocket"
 )
 
 type Service struct {
@@ -23,46 +27,420 @@ func NewService(repo *queue.Repository) (*Service, error) {
 	}, nil
 }
 
-func (s *Service) Push(c *gin.Context, force bool) {
-	key := c.Query("key")
-	value := c.Query("value")
+// item is an item as producers send it over HTTP, with a TTL and delay
+// relative to now.
+type item struct {
+	models.Data
+	TTL   string `json:"ttl,omitempty"`
+	Delay string `json:"delay,omitempty"`
+}
+
+// entry parses TTL and delay of the item.
+func (i item) entry() (Entry, error) {
+	e := Entry{Data: i.Data}
+	if i.TTL != "" {
+		ttl, err := time.ParseDuration(i.TTL)
+		if err != nil || ttl <= 0 {
+			return e, models.ErrInvalidTTL
+		}
+		e.TTL = ttl
+	}
+	if i.Delay != "" {
+		delay, err := time.ParseDuration(i.Delay)
+		if err != nil || delay < 0 {
+			return e, models.ErrInvalidDelay
+		}
+		e.Delay = delay
+	}
+	return e, nil
+}
+
+// Entry is an item to push, with a TTL and delay relative to now, zero for
+// none.
+type Entry struct {
+	Data  models.Data
+	TTL   time.Duration
+	Delay time.Duration
+}
+
+// data sets expiry and delivery time of the item from its TTL and delay,
+// if it has them.
+func (e Entry) data() (models.Data, error) {
+	d := e.Data
+	d.Deliveries = 0
+	if e.TTL < 0 {
+		return d, models.ErrInvalidTTL
+	}
+	if e.Delay < 0 {
+		return d, models.ErrInvalidDelay
+	}
+	if e.TTL > 0 {
+		expiresAt := time.Now().Add(e.TTL)
+		d.ExpiresAt = &expiresAt
+	}
+	if e.Delay > 0 {
+		deliverAt := time.Now().Add(e.Delay)
+		d.DeliverAt = &deliverAt
+	}
+	return d, nil
+}
+
+// PushEntry pushes e into queue name once as many replicas as level
+// confirmed it, empty for the level of the queue.
+func (s *Service) PushEntry(name string, e Entry, level string) (models.Data, error) {
+	d, err := e.data()
+	if err != nil {
+		return models.Data{}, err
+	}
+	return s.repo.Push(name, d, level)
+}
+
+// PushEntries pushes entries into queue name at once and reports the
+// outcome of each one. Like PushEntry it waits for level.
+func (s *Service) PushEntries(name string, entries []Entry, level string) ([]models.PushResult, error) {
+	data := make([]models.Data, len(entries))
+	for i := range entries {
+		var err error
+		data[i], err = entries[i].data()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.repo.PushBatch(name, data, level)
+}
+
+func (s *Service) Push(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	body = bytes.TrimSpace(body)
+
+	// Without a body the item comes from the query, like it always did.
+	if len(body) == 0 {
+		var priority int
+		if v := c.Query("priority"); v != "" {
+			priority, err = strconv.Atoi(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, models.ErrInvalidPriority.Error())
+				return
+			}
+		}
+		var deliverAt *time.Time
+		if v := c.Query("deliverAt"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, models.ErrInvalidDelay.Error())
+				return
+			}
+			deliverAt = &t
+		}
+
+		s.push(c, item{
+			Data: models.Data{
+				Key:            c.Query("key"),
+				Value:          c.Query("value"),
+				Priority:       priority,
+				ContentType:    c.Query("contentType"),
+				Producer:       c.Query("producer"),
+				DeliverAt:      deliverAt,
+				IdempotencyKey: c.GetHeader("Idempotency-Key"),
+			},
+			TTL:   c.Query("ttl"),
+			Delay: c.Query("delay"),
+		})
+		return
+	}
+
+	if body[0] == '[' {
+		var items []item
+		if err := json.Unmarshal(body, &items); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrParseData.Error())
+			return
+		}
+		s.pushBatch(c, items)
+		return
+	}
+
+	var i item
+	if err := json.Unmarshal(body, &i); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrParseData.Error())
+		return
+	}
+	if i.IdempotencyKey == "" {
+		i.IdempotencyKey = c.GetHeader("Idempotency-Key")
+	}
+	s.push(c, i)
+}
+
+func (s *Service) push(c *gin.Context, i item) {
+	e, err := i.entry()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp, err := s.PushEntry(c.Param("name"), e, c.Query("consistency"))
+	if err != nil {
+		c.JSON(status(err), err.Error())
+	} else {
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// pushBatch pushes items at once and reports the outcome of each one.
+func (s *Service) pushBatch(c *gin.Context, items []item) {
+	entries := make([]Entry, len(items))
+	for i := range items {
+		var err error
+		entries[i], err = items[i].entry()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	resp, err := s.PushEntries(c.Param("name"), entries, c.Query("consistency"))
+	if err != nil {
+		c.JSON(status(err), err.Error())
+	} else {
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (s *Service) Pull(c *gin.Context) {
+	// Zero count pulls a single item instead of a list of them. Long polls
+	// are held to the batch limit here, the others by the repository too.
+	var count int
+	if v := c.Query("count"); v != "" {
+		var err error
+		count, err = strconv.Atoi(v)
+		if err != nil || count <= 0 || count > s.repo.MaxBatch() {
+			c.JSON(http.StatusBadRequest, models.ErrInvalidBatch.Error())
+			return
+		}
+	}
+
+	f, err := filter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if v := c.Query("wait"); v != "" {
+		wait, err := time.ParseDuration(v)
+		if err != nil || wait <= 0 {
+			c.JSON(http.StatusBadRequest, models.ErrInvalidWait.Error())
+			return
+		}
+		s.wait(c, count, wait, c.Query("lease") == "true", f)
+		return
+	}
+
+	var filters []models.Filter
+	if !f.Empty() {
+		filters = append(filters, f)
+	}
+	if c.Query("lease") == "true" {
+		s.lease(c, count, filters)
+		return
+	}
+
+	var resp interface{}
+	if count > 0 {
+		resp, err = s.repo.PullBatch(c.Param("name"), count, filters...)
+	} else {
+		resp, err = s.repo.Pull(c.Param("name"), filters...)
+	}
+	if err != nil {
+		c.JSON(status(err), err.Error())
+	} else {
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// lease pulls head of the queue without removing it until it is acked.
+func (s *Service) lease(c *gin.Context, count int, filters []models.Filter) {
+	var visibility time.Duration
+	if v := c.Query("visibility"); v != "" {
+		var err error
+		visibility, err = time.ParseDuration(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	var resp interface{}
+	var err error
+	if count > 0 {
+		resp, err = s.repo.LeaseBatch(c.Param("name"), visibility, count, filters...)
+	} else {
+		resp, err = s.repo.Lease(c.Param("name"), visibility, filters...)
+	}
+	if err != nil {
+		c.JSON(status(err), err.Error())
+	} else {
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// filter reads the items a consumer wants from query parameters keyPrefix,
+// keyPattern, contentType, producer and header, the last given as
+// name:value and as many times as needed.
+func filter(c *gin.Context) (models.Filter, error) {
+	f := models.Filter{
+		KeyPrefix:   c.Query("keyPrefix"),
+		KeyPattern:  c.Query("keyPattern"),
+		ContentType: c.Query("contentType"),
+		Producer:    c.Query("producer"),
+	}
+	for _, h := range c.QueryArray("header") {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok || name == "" {
+			return models.Filter{}, models.ErrInvalidFilter
+		}
+		if f.Headers == nil {
+			f.Headers = make(map[string]string)
+		}
+		f.Headers[name] = value
+	}
+	return f, f.Validate()
+}
+
+func (s *Service) Peek(c *gin.Context) {
+	resp, err := s.repo.Peek(c.Param("name"))
+	if err != nil {
+		c.JSON(status(err), err.Error())
+	} else {
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (s *Service) Ack(c *gin.Context) {
+	receipt := c.Query("receipt")
+
+	resp, err := s.repo.Ack(c.Param("name"), receipt)
+	if err != nil {
+		c.JSON(status(err), err.Error())
+	} else {
+		c.JSON(http.StatusOK, resp)
+	}
+}
 
-	resp, err := s.repo.Push(
-		models.Data{
-			Key:   key,
-			Value: value,
-		},
-		force,
-	)
+func (s *Service) DeadLetter(c *gin.Context) {
+	resp, err := s.repo.DeadLetter(c.Param("name"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err)
+		c.JSON(status(err), err.Error())
 	} else {
 		c.JSON(http.StatusOK, resp)
 	}
 }
 
-func (s *Service) Pull(c *gin.Context, force bool) {
+func (s *Service) Redrive(c *gin.Context) {
 	key := c.Query("key")
-	if !force {
-		key = ""
+
+	resp, err := s.repo.Redrive(c.Param("name"), key)
+	if err != nil {
+		c.JSON(status(err), err.Error())
+	} else {
+		c.JSON(http.StatusOK, resp)
 	}
+}
+
+func (s *Service) Purge(c *gin.Context) {
+	key := c.Query("key")
 
-	resp, err := s.repo.Pull(key)
+	resp, err := s.repo.Purge(c.Param("name"), key)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err)
+		c.JSON(status(err), err.Error())
 	} else {
 		c.JSON(http.StatusOK, resp)
 	}
 }
 
-func (s *Service) Subscribe(c *websocket.Conn, addr string) string {
-	return s.repo.Subscribe(c, addr)
+func (s *Service) Subscribe(name string, group string, c *models.Conn, addr string, sub models.Subscription) (string, error) {
+	return s.repo.Subscribe(name, group, c, addr, sub)
+}
+
+func (s *Service) Unsubscribe(name string, group string, addr string) error {
+	return s.repo.Unsubscribe(name, group, addr)
 }
 
-func (s *Service) Unsubscribe(c *websocket.Conn, addr string) error {
-	return s.repo.Unsubscribe(c, addr)
+func (s *Service) Settle(name string, group string, addr string, id string, nack bool) error {
+	return s.repo.Settle(name, group, addr, id, nack)
+}
+
+func (s *Service) Credit(name string, group string, addr string, n int) error {
+	return s.repo.Credit(name, group, addr, n)
 }
 
 func (s *Service) Copy(c *gin.Context) {
-	c.JSON(http.StatusOK, s.repo.Copy())
+	resp, err := s.repo.Copy(c.Param("name"))
+	if err != nil {
+		c.JSON(status(err), err.Error())
+	} else {
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (s *Service) Queues(c *gin.Context) {
+	c.JSON(http.StatusOK, s.repo.Queues())
+}
+
+func (s *Service) CreateQueue(c *gin.Context) {
+	name := c.Param("name")
+
+	err := s.repo.CreateQueue(name, c.Query("mode"))
+	if err != nil {
+		c.JSON(status(err), err.Error())
+	} else {
+		c.JSON(http.StatusOK, name)
+	}
+}
+
+func (s *Service) SetMode(c *gin.Context) {
+	resp, err := s.repo.SetMode(c.Param("name"), c.Query("mode"))
+	if err != nil {
+		c.JSON(status(err), err.Error())
+	} else {
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (s *Service) SetConsistency(c *gin.Context) {
+	resp, err := s.repo.SetConsistency(c.Param("name"), c.Query("level"))
+	if err != nil {
+		c.JSON(status(err), err.Error())
+	} else {
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (s *Service) DeleteQueue(c *gin.Context) {
+	name := c.Param("name")
+
+	err := s.repo.DeleteQueue(name)
+	if err != nil {
+		c.JSON(status(err), err.Error())
+	} else {
+		c.JSON(http.StatusOK, name)
+	}
+}
+
+// Reconciliation reports the anti-entropy rounds of the leader.
+func (s *Service) Reconciliation(c *gin.Context) {
+	c.JSON(http.StatusOK, s.repo.Reconciliation())
+}
+
+// Reconcile compares every replica with the leader now and repairs the
+// diverged ones.
+func (s *Service) Reconcile(c *gin.Context) {
+	resp, err := s.repo.Reconcile()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, err.Error())
+	} else {
+		c.JSON(http.StatusOK, resp)
+	}
 }
@@ -0,0 +1,44 @@
+package queue
+
+import (
+	"net/http"
+
+	models "github.com/System-Analysis-and-Design-2023-SUT/Server/models/queue"
+)
+
+// statusOf maps errors of the queues to HTTP statuses, so clients tell a
+// missing queue or a bad request from a failure of the server.
+var statusOf = map[error]int{
+	models.ErrParseData:          http.StatusBadRequest,
+	models.ErrInvalidPriority:    http.StatusBadRequest,
+	models.ErrInvalidDelay:       http.StatusBadRequest,
+	models.ErrInvalidTTL:         http.StatusBadRequest,
+	models.ErrInvalidBatch:       http.StatusBadRequest,
+	models.ErrInvalidFilter:      http.StatusBadRequest,
+	models.ErrInvalidWait:        http.StatusBadRequest,
+	models.ErrInvalidQueueName:   http.StatusBadRequest,
+	models.ErrDefaultQueue:       http.StatusBadRequest,
+	models.ErrInvalidGroupName:   http.StatusBadRequest,
+	models.ErrInvalidOffset:      http.StatusBadRequest,
+	models.ErrInvalidMode:        http.StatusBadRequest,
+	models.ErrInvalidConsistency: http.StatusBadRequest,
+	models.ErrInvalidCredit:      http.StatusBadRequest,
+	models.ErrEmptyList:          http.StatusNotFound,
+	models.ErrKeyNotFound:        http.StatusNotFound,
+	models.ErrObjectNotFound:     http.StatusNotFound,
+	models.ErrReceiptNotFound:    http.StatusNotFound,
+	models.ErrQueueNotFound:      http.StatusNotFound,
+	models.ErrGroupNotFound:      http.StatusNotFound,
+	models.ErrKeyExist:           http.StatusConflict,
+	models.ErrQueueExist:         http.StatusConflict,
+	models.ErrGroupExist:         http.StatusConflict,
+	models.ErrNotConfirmed:       http.StatusServiceUnavailable,
+}
+
+// status returns the HTTP status err is answered with.
+func status(err error) int {
+	if code, ok := statusOf[err]; ok {
+		return code
+	}
+	return http.StatusInternalServerError
+}
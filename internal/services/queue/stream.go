@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	models "github.com/System-Analysis-and-Design-2023-SUT/Server/models/queue"
+	"github.com/gin-gonic/gin"
+)
+
+// keepAlive is how long an idle stream waits before it sends a comment.
+var keepAlive = models.PingInterval
+
+// wait pulls like Pull does, waiting up to wait for the first item f picks
+// if there is none. Items come through the subscribers of the queue, leased
+// with the default visibility timeout if lease is set.
+func (s *Service) wait(c *gin.Context, count int, wait time.Duration, lease bool, f models.Filter) {
+	credit := count
+	if credit == 0 {
+		credit = 1
+	}
+
+	conn, stop, err := s.repo.Listen(c.Param("name"), "", models.Subscription{Filter: f, Lease: lease, Credit: credit})
+	if err != nil {
+		c.JSON(status(err), err.Error())
+		return
+	}
+	defer stop()
+
+	h, err := conn.Next(c.Request.Context().Done(), wait)
+	if err != nil {
+		// Nothing came in time, like a pull from an empty queue.
+		c.JSON(status(models.ErrEmptyList), models.ErrEmptyList.Error())
+		return
+	}
+	handed := []models.Handed{h}
+	for len(handed) < count {
+		h, err := conn.Next(nil, 0)
+		if err != nil {
+			break
+		}
+		handed = append(handed, h)
+	}
+
+	items := make([]interface{}, len(handed))
+	for i, h := range handed {
+		if lease {
+			items[i] = models.Lease{Data: h.Data, Receipt: h.Receipt, Deadline: h.Deadline}
+		} else {
+			items[i] = h.Data
+		}
+	}
+	if count > 0 {
+		c.JSON(http.StatusOK, items)
+	} else {
+		c.JSON(http.StatusOK, items[0])
+	}
+	for _, h := range handed {
+		h.Written(nil)
+	}
+}
+
+// Stream sends items of the queue, or of its consumer group, the filter of
+// the query picks as server-sent events until the client goes away. Items come through the
+// subscribers of the queue and are acked once written.
+func (s *Service) Stream(c *gin.Context) {
+	f, err := filter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	conn, stop, err := s.repo.Listen(c.Param("name"), c.Param("group"), models.Subscription{Filter: f})
+	if err != nil {
+		c.JSON(status(err), err.Error())
+		return
+	}
+	defer stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	for {
+		h, err := conn.Next(c.Request.Context().Done(), keepAlive)
+		if err == models.ErrEmptyList {
+			// Comments keep proxies from closing an idle stream.
+			_, err = fmt.Fprint(c.Writer, ": ping\n\n")
+			c.Writer.Flush()
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		b, err := json.Marshal(h.Data)
+		if err == nil {
+			_, err = fmt.Fprintf(c.Writer, "id: %s\nevent: message\ndata: %s\n\n", h.ID(), b)
+			c.Writer.Flush()
+		}
+		h.Written(err)
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	repo "github.com/System-Analysis-and-Design-2023-SUT/Server/internal/repository/queue"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/settings"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/testnode"
+	models "github.com/System-Analysis-and-Design-2023-SUT/Server/models/queue"
+	"github.com/gin-gonic/gin"
+)
+
+// newServer serves pulls and streams of a single node cluster.
+func newServer(t *testing.T) (*httptest.Server, *repo.Repository) {
+	var st settings.Settings
+	r := testnode.NewRepository(t, &st)
+
+	s, err := NewService(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/pull", s.Pull)
+	router.GET("/stream", s.Stream)
+
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return srv, r
+}
+
+func TestWait(t *testing.T) {
+	srv, r := newServer(t)
+
+	t.Run("times out on an empty queue", func(t *testing.T) {
+		start := time.Now()
+		resp, err := http.Get(srv.URL + "/pull?wait=100ms")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var msg string
+		_ = json.NewDecoder(resp.Body).Decode(&msg)
+		if resp.StatusCode != http.StatusNotFound || msg != models.ErrEmptyList.Error() {
+			t.Fatalf("expected 404 %q, got %d %q", models.ErrEmptyList, resp.StatusCode, msg)
+		}
+		if time.Since(start) < 100*time.Millisecond {
+			t.Fatal("long poll returned before its wait")
+		}
+	})
+
+	t.Run("rejects a count over the batch limit", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/pull?wait=5s&count=" + strconv.Itoa(r.MaxBatch()+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var msg string
+		_ = json.NewDecoder(resp.Body).Decode(&msg)
+		if resp.StatusCode != http.StatusBadRequest || msg != models.ErrInvalidBatch.Error() {
+			t.Fatalf("expected 400 %q, got %d %q", models.ErrInvalidBatch, resp.StatusCode, msg)
+		}
+	})
+
+	t.Run("returns an item pushed meanwhile", func(t *testing.T) {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			_, _ = r.Push("", models.Data{Key: "late"}, "")
+		}()
+
+		resp, err := http.Get(srv.URL + "/pull?wait=5s")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var d models.Data
+		if err := json.NewDecoder(resp.Body).Decode(&d); err != nil || resp.StatusCode != http.StatusOK || d.Key != "late" {
+			t.Fatalf("unexpected response %d %v %v", resp.StatusCode, d, err)
+		}
+	})
+
+	t.Run("drains up to count items", func(t *testing.T) {
+		for _, k := range []string{"a", "b", "c"} {
+			if _, err := r.Push("", models.Data{Key: k}, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Items are handed as the subscriber gets them, a poll returns the
+		// ones it got by then and the others go back to the queue.
+		got := make([]string, 0)
+		for len(got) < 3 {
+			resp, err := http.Get(srv.URL + "/pull?wait=1s&count=3")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var items []models.Data
+			err = json.NewDecoder(resp.Body).Decode(&items)
+			resp.Body.Close()
+			if err != nil || resp.StatusCode != http.StatusOK || len(items) == 0 || len(items) > 3 {
+				t.Fatalf("unexpected response %d %v %v", resp.StatusCode, items, err)
+			}
+			for _, d := range items {
+				got = append(got, d.Key)
+			}
+		}
+		sort.Strings(got)
+		if strings.Join(got, "") != "abc" {
+			t.Fatalf("expected a, b and c once each, got %v", got)
+		}
+	})
+}
+
+func TestStream(t *testing.T) {
+	// The server is closed first, so no stream reads keepAlive meanwhile.
+	keepAlive = 20 * time.Millisecond
+	t.Cleanup(func() { keepAlive = models.PingInterval })
+
+	srv, r := newServer(t)
+	resp, err := http.Get(srv.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// readEvent returns the next event, its lines without the blank one
+	// ending it.
+	body := bufio.NewReader(resp.Body)
+	readEvent := func() []string {
+		t.Helper()
+		lines := make([]string, 0)
+		for {
+			line, err := body.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" {
+				return lines
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	// An idle stream is kept alive with comments.
+	if ev := readEvent(); len(ev) != 1 || ev[0] != ": ping" {
+		t.Fatalf("expected a keepalive comment, got %q", ev)
+	}
+
+	if _, err := r.Push("", models.Data{Key: "a", Value: "1"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	ev := readEvent()
+	for len(ev) == 1 && ev[0] == ": ping" {
+		ev = readEvent()
+	}
+	if len(ev) != 3 || !strings.HasPrefix(ev[0], "id: ") || ev[1] != "event: message" || !strings.HasPrefix(ev[2], "data: ") {
+		t.Fatalf("unexpected event %q", ev)
+	}
+	var d models.Data
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(ev[2], "data: ")), &d); err != nil || d.Key != "a" || d.Value != "1" {
+		t.Fatalf("unexpected data %v %v", d, err)
+	}
+}
@@ -5,3 +5,15 @@ import "github.com/pkg/errors"
 var ErrSettingNameEmpty = errors.New("global.name field is required.")
 var ErrSettingInvalidEnvironment = errors.New("configs.environment field value is invalid.")
 var ErrSettingDuplicatedServerPorts = errors.New("duplicated ports has been found: port number fields in setting.yml should have different values.")
+var ErrSettingInvalidFsyncPolicy = errors.New("storage.fsync field value is invalid.")
+var ErrSettingInvalidCompactInterval = errors.New("storage.compactInterval field should be positive.")
+var ErrSettingInvalidConsensusTimeouts = errors.New("consensus.heartbeatInterval should be positive and less than consensus.electionTimeout.")
+var ErrSettingInvalidMemberCount = errors.New("replica.memberCount field should be positive.")
+var ErrSettingInvalidVisibilityTimeout = errors.New("queue.visibilityTimeout field should be positive.")
+var ErrSettingInvalidMaxDeliveries = errors.New("queue.maxDeliveries field should not be negative.")
+var ErrSettingInvalidMaxBatch = errors.New("queue.maxBatch field should be positive.")
+var ErrSettingInvalidRetention = errors.New("queue.retention field should not be negative.")
+var ErrSettingInvalidDedupWindow = errors.New("queue.dedupWindow field should not be negative.")
+var ErrSettingInvalidConsistency = errors.New("queue.consistency field should be one, quorum or all.")
+var ErrSettingInvalidReconcileInterval = errors.New("replica.reconcileInterval field should not be negative.")
+var ErrSettingInvalidRouting = errors.New("replica.routing field should be proxy or redirect.")
@@ -2,6 +2,9 @@ package settings
 
 import (
 	"time"
+
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/raft"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/pkg/wal"
 )
 
 const (
@@ -10,6 +13,13 @@ const (
 	Test    string = "test"
 )
 
+const (
+	// RoutingProxy makes a follower pass requests to the leader.
+	RoutingProxy string = "proxy"
+	// RoutingRedirect makes a follower redirect clients to the leader.
+	RoutingRedirect string = "redirect"
+)
+
 type Settings struct {
 	Global struct {
 		Name              string        `yaml:"name" env:"GLOBAL_NAME" env-default:"sad-server" env-description:"Instance Name"`
@@ -20,7 +30,8 @@ type Settings struct {
 		MaxHeaderBytes    int           `yaml:"maxHeaderBytes" env:"GLOBAL_MAX_HEADER_BYTES" env-default:"8196" env-description:"Max header bytes of http server"`
 		APIPort           int           `yaml:"apiPort" env:"GLOBAL_API_PORT" env-default:"8080" env-description:"Default Port of API server"`
 		MemberlistPort    int           `yaml:"memberlistPort" env:"GLOBAL_MEMBER_LIST_PORT" env-default:"8081" env-description:"Default Port of Memberlist server"`
-		GossopingPort     int           `yaml:"gossopingPort" env:"GLOBAL_GOSSOPING_PORT" env-default:"8082" env-description:"Default Port of Gossoping server"`
+		GossopingPort     int           `yaml:"gossopingPort" env:"GLOBAL_GOSSOPING_PORT" env-default:"8082" env-description:"Default Port of Gossoping server, which carries raft messages between nodes"`
+		GRPCPort          int           `yaml:"grpcPort" env:"GLOBAL_GRPC_PORT" env-default:"8083" env-description:"Default Port of gRPC server"`
 		Environment       string        `yaml:"environment" env:"CONFIG_MODE" env-default:"file" env-description:"Execution mode of Gin framework"`
 	} `yaml:"global"`
 	Replica struct {
@@ -28,7 +39,29 @@ type Settings struct {
 		MemberCount int      `yaml:"memberCount" env:"MEMBER_COUNT" env-default:"3" env-description:"Count of member list"`
 		BindAddress string   `yaml:"bindAddress" env:"BIND_ADDRESS" env-default:"0.0.0.0" env-description:"Bind address of memberlist"`
 		Subnet      string   `yaml:"subnet" env:"SUBNET" env-default:"10.0.9.0/28" env-description:"Subnet address of memberlist"`
+		Routing     string   `yaml:"routing" env:"ROUTING" env-default:"proxy" env-description:"How a follower gets requests to the leader: proxy or redirect"`
+		// ReconcileInterval is the period of anti-entropy rounds.
+		ReconcileInterval time.Duration `yaml:"reconcileInterval" env:"RECONCILE_INTERVAL" env-default:"1m" env-description:"Period of comparing replicas with the leader and repairing the diverged ones, 0 to disable"`
 	} `yaml:"replica"`
+	Storage struct {
+		Path            string        `yaml:"path" env:"STORAGE_PATH" env-default:"/opt/server/data" env-description:"Directory of write-ahead log and snapshots"`
+		Fsync           string        `yaml:"fsync" env:"STORAGE_FSYNC" env-default:"always" env-description:"Fsync policy of write-ahead log: always, interval or never"`
+		FsyncInterval   time.Duration `yaml:"fsyncInterval" env:"STORAGE_FSYNC_INTERVAL" env-default:"1s" env-description:"Fsync period of write-ahead log when policy is interval"`
+		CompactInterval time.Duration `yaml:"compactInterval" env:"STORAGE_COMPACT_INTERVAL" env-default:"5m" env-description:"Period of compacting write-ahead log into a snapshot"`
+	} `yaml:"storage"`
+	Consensus struct {
+		ElectionTimeout   time.Duration `yaml:"electionTimeout" env:"CONSENSUS_ELECTION_TIMEOUT" env-default:"1s" env-description:"Minimum time without a leader before starting an election"`
+		HeartbeatInterval time.Duration `yaml:"heartbeatInterval" env:"CONSENSUS_HEARTBEAT_INTERVAL" env-default:"150ms" env-description:"Period of leader heartbeats"`
+		ProposeTimeout    time.Duration `yaml:"proposeTimeout" env:"CONSENSUS_PROPOSE_TIMEOUT" env-default:"5s" env-description:"Maximum time to wait for a command to be committed"`
+	} `yaml:"consensus"`
+	Queue struct {
+		VisibilityTimeout time.Duration `yaml:"visibilityTimeout" env:"QUEUE_VISIBILITY_TIMEOUT" env-default:"30s" env-description:"Default time a leased item stays hidden before it reappears"`
+		MaxDeliveries     int           `yaml:"maxDeliveries" env:"QUEUE_MAX_DELIVERIES" env-default:"5" env-description:"Delivery attempts before an item moves to the dead-letter queue, 0 for unlimited"`
+		MaxBatch          int           `yaml:"maxBatch" env:"QUEUE_MAX_BATCH" env-default:"1000" env-description:"Maximum items pushed or pulled by a single request"`
+		Retention         int           `yaml:"retention" env:"QUEUE_RETENTION" env-default:"10000" env-description:"Items kept in the log of a queue for consumer groups, 0 keeps every item"`
+		DedupWindow       time.Duration `yaml:"dedupWindow" env:"QUEUE_DEDUP_WINDOW" env-default:"5m" env-description:"Time a pushed item is remembered to answer retries with it, 0 to disable"`
+		Consistency       string        `yaml:"consistency" env:"QUEUE_CONSISTENCY" env-default:"quorum" env-description:"Replicas confirming a push unless its queue or request says otherwise: one, quorum or all"`
+	} `yaml:"queue"`
 }
 
 func (settings Settings) IsValid() (bool, error) {
@@ -43,6 +76,7 @@ func (settings Settings) IsValid() (bool, error) {
 		settings.Global.APIPort,
 		settings.Global.MemberlistPort,
 		settings.Global.GossopingPort,
+		settings.Global.GRPCPort,
 	} {
 		_, exist := duplicatedPorts[item]
 		if exist {
@@ -59,5 +93,46 @@ func (settings Settings) IsValid() (bool, error) {
 	if settings.Global.Environment != Debug && settings.Global.Environment != Release && settings.Global.Environment != Test {
 		return false, ErrSettingInvalidEnvironment
 	}
+
+	if !wal.SyncPolicy(settings.Storage.Fsync).IsValid() {
+		return false, ErrSettingInvalidFsyncPolicy
+	}
+	if settings.Storage.Fsync == string(wal.SyncInterval) && settings.Storage.FsyncInterval <= 0 {
+		return false, ErrSettingInvalidFsyncPolicy
+	}
+	if settings.Storage.CompactInterval <= 0 {
+		return false, ErrSettingInvalidCompactInterval
+	}
+
+	if settings.Consensus.HeartbeatInterval <= 0 || settings.Consensus.ElectionTimeout <= settings.Consensus.HeartbeatInterval || settings.Consensus.ProposeTimeout <= 0 {
+		return false, ErrSettingInvalidConsensusTimeouts
+	}
+	if settings.Queue.VisibilityTimeout <= 0 {
+		return false, ErrSettingInvalidVisibilityTimeout
+	}
+	if settings.Queue.MaxDeliveries < 0 {
+		return false, ErrSettingInvalidMaxDeliveries
+	}
+	if settings.Queue.MaxBatch <= 0 {
+		return false, ErrSettingInvalidMaxBatch
+	}
+	if settings.Queue.Retention < 0 {
+		return false, ErrSettingInvalidRetention
+	}
+	if settings.Queue.DedupWindow < 0 {
+		return false, ErrSettingInvalidDedupWindow
+	}
+	if !raft.Consistency(settings.Queue.Consistency).IsValid() {
+		return false, ErrSettingInvalidConsistency
+	}
+	if settings.Replica.MemberCount <= 0 {
+		return false, ErrSettingInvalidMemberCount
+	}
+	if settings.Replica.Routing != RoutingProxy && settings.Replica.Routing != RoutingRedirect {
+		return false, ErrSettingInvalidRouting
+	}
+	if settings.Replica.ReconcileInterval < 0 {
+		return false, ErrSettingInvalidReconcileInterval
+	}
 	return true, nil
 }
@@ -0,0 +1,67 @@
+// Package testnode starts single node clusters for tests.
+package testnode
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/helper"
+	repo "github.com/System-Analysis-and-Design-2023-SUT/Server/internal/repository/queue"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/internal/settings"
+	"github.com/System-Analysis-and-Design-2023-SUT/Server/pkg/wal"
+	"github.com/hashicorp/memberlist"
+)
+
+// port is where the helper would reach other members, a single node has
+// none to reach.
+const port = 8082
+
+// NewRepository starts a single node cluster and waits for it to lead. It
+// fills in the membership, consensus, storage and queue settings of st
+// and keeps the rest. Everything is stopped when the test ends.
+func NewRepository(t testing.TB, st *settings.Settings) *repo.Repository {
+	t.Helper()
+
+	config := memberlist.DefaultLocalConfig()
+	config.Name = "test-node"
+	config.BindAddr = "127.0.0.1"
+	config.BindPort = 0
+	config.LogOutput = io.Discard
+	list, err := memberlist.Create(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { list.Shutdown() })
+	list.LocalNode().Meta = helper.Meta{ID: "node-1", Address: "127.0.0.1"}.Encode()
+
+	h, err := helper.NewHelper(list, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := wal.Open(t.TempDir(), wal.SyncNever, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st.Replica.MemberCount = 1
+	st.Consensus.ElectionTimeout = 50 * time.Millisecond
+	st.Consensus.HeartbeatInterval = 10 * time.Millisecond
+	st.Consensus.ProposeTimeout = time.Second
+	st.Storage.CompactInterval = time.Minute
+	st.Queue.VisibilityTimeout = time.Minute
+	st.Queue.MaxBatch = 10
+	st.Queue.DedupWindow = time.Minute
+
+	r, err := repo.NewRepository(st, h, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); !r.IsLeader(); {
+		if time.Now().After(deadline) {
+			t.Fatal("no leader elected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return r
+}
@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// PushResult reports how pushing one item of a batch went.
+type PushResult struct {
+	Key   string `json:"key"`
+	Error string `json:"error,omitempty"`
+	// Duplicate tells the item was a retry and the original was kept.
+	Duplicate bool `json:"duplicate,omitempty"`
+	// Sequence is the sequence number of the item kept.
+	Sequence uint64 `json:"sequence,omitempty"`
+}
+
+// PushBatch pushes items in order like PushOnce does. An item that fails
+// does not stop the ones after it.
+func (q *Queue) PushBatch(items []Data, now time.Time, window time.Duration) []PushResult {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.forget(now, window)
+	results := make([]PushResult, len(items))
+	for i, d := range items {
+		results[i].Key = d.Key
+		kept, duplicate, err := q.pushOnce(d, now, window)
+		if err != nil {
+			results[i].Error = err.Error()
+		} else {
+			results[i].Sequence = kept.Sequence
+		}
+		results[i].Duplicate = duplicate
+	}
+	return results
+}
+
+// PullBatch removes up to n items from head of the queue, dropping items
+// expired at now on the way. With filters it removes the items one of them
+// picks instead.
+func (q *Queue) PullBatch(n int, now time.Time, filters ...Filter) []Data {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	pulled := make([]Data, 0)
+	for e := q.match(now, filters); e != nil && len(pulled) < n; e = q.match(now, filters) {
+		d := q.remove(e)
+		delete(q.KeySet, d.Key)
+		pulled = append(pulled, d)
+	}
+	return pulled
+}
+
+// LeaseBatch leases an item under each receipt for as long as there are
+// items at head of the queue, or items one of filters picks.
+func (q *Queue) LeaseBatch(receipts []string, now, deadline time.Time, filters ...Filter) []Lease {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	leases := make([]Lease, 0)
+	for _, receipt := range receipts {
+		l, err := q.lease(receipt, now, deadline, filters)
+		if err != nil {
+			break
+		}
+		leases = append(leases, l)
+	}
+	return leases
+}
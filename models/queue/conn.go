@@ -0,0 +1,252 @@
+package models
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// connBuffer is how many messages a connection may fall behind before
+	// it is disconnected.
+	connBuffer = 256
+	// writeWait is how long a single write may take.
+	writeWait = 10 * time.Second
+
+	// PingInterval is how often connections are pinged and PongWait how
+	// long a connection may stay silent before it is considered gone.
+	PingInterval = 30 * time.Second
+	PongWait     = 2 * PingInterval
+)
+
+// Conn is a connection of subscribers. Everything written to it goes
+// through out and its own writer, so a slow connection holds back nobody
+// else. Connections of HTTP consumers have no WebSocket and are read with
+// Next instead.
+type Conn struct {
+	ws *websocket.Conn
+	// mu guards version, out and closed.
+	mu sync.Mutex
+	// version is the protocol the client speaks, zero for plain text.
+	version int
+	out     chan message
+	closed  bool
+}
+
+// message is a delivery or a plain reply waiting for the writer. Deliveries
+// of a member are reported back to its subscriber once written.
+type message struct {
+	body     []byte
+	delivery *Delivery
+	member   *Member
+}
+
+// writeLoop writes messages and pings the client until out is closed.
+// Deliveries left once the connection failed are reported as not
+// delivered.
+func (c *Conn) writeLoop() {
+	ping := time.NewTicker(PingInterval)
+	defer ping.Stop()
+
+	var err error
+	for {
+		select {
+		case msg, ok := <-c.out:
+			if !ok {
+				return
+			}
+			if err == nil {
+				err = c.write(msg)
+				if err != nil {
+					_ = c.ws.Close()
+				}
+			}
+			if msg.member != nil {
+				msg.member.sub.written(msg.member, *msg.delivery, err)
+			}
+		case <-ping.C:
+			if err == nil {
+				err = c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+				if err != nil {
+					_ = c.ws.Close()
+				}
+			}
+		}
+	}
+}
+
+func (c *Conn) write(msg message) error {
+	body := msg.body
+	if body == nil {
+		var err error
+		body, err = c.encode(*msg.delivery)
+		if err != nil {
+			return err
+		}
+	}
+
+	_ = c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.ws.WriteMessage(websocket.TextMessage, body)
+}
+
+// encode turns d into what the client understands, the bare item for plain
+// text clients and a message event for the others.
+func (c *Conn) encode(d Delivery) ([]byte, error) {
+	if c.Version() == 0 {
+		return json.Marshal(d.Data)
+	}
+	return json.Marshal(Event{
+		Type:  EventMessage,
+		ID:    d.ID(),
+		Queue: d.Queue,
+		Group: d.Group,
+		Data:  &d.Data,
+	})
+}
+
+// Handed is a delivery taken from a connection with Next. The caller tells
+// with Written whether it got to the consumer.
+type Handed struct {
+	Delivery
+	member *Member
+}
+
+// Written settles the delivery like the writer of a WebSocket would.
+func (h Handed) Written(err error) {
+	if h.member != nil {
+		h.member.sub.written(h.member, h.Delivery, err)
+	}
+}
+
+// Next takes the next delivery, waiting up to timeout for one unless done
+// is closed first. It returns ErrEmptyList if none came in time and
+// ErrConnClosed once the connection is closed or done.
+func (c *Conn) Next(done <-chan struct{}, timeout time.Duration) (Handed, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		var msg message
+		var ok bool
+		// A delivery already queued wins over a timeout of zero.
+		select {
+		case msg, ok = <-c.out:
+		default:
+			select {
+			case msg, ok = <-c.out:
+			case <-timer.C:
+				return Handed{}, ErrEmptyList
+			case <-done:
+				return Handed{}, ErrConnClosed
+			}
+		}
+
+		if !ok {
+			return Handed{}, ErrConnClosed
+		}
+		if msg.delivery != nil {
+			return Handed{Delivery: *msg.delivery, member: msg.member}, nil
+		}
+	}
+}
+
+// send queues msg for the writer. A connection too far behind is closed.
+func (c *Conn) send(msg message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrConnClosed
+	}
+	select {
+	case c.out <- msg:
+		return nil
+	default:
+		c.close()
+		return ErrConnClosed
+	}
+}
+
+// full reports whether the writer has no room for another message.
+func (c *Conn) full() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed || len(c.out) == cap(c.out)
+}
+
+// Version returns the protocol the client speaks, zero for plain text.
+func (c *Conn) Version() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.version
+}
+
+// SetVersion sets the protocol the client agreed to speak.
+func (c *Conn) SetVersion(version int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.version = version
+}
+
+// Write queues body to be written after what was queued before.
+func (c *Conn) Write(body []byte) error {
+	return c.send(message{body: body})
+}
+
+// WriteEvent queues e to be written after what was queued before.
+func (c *Conn) WriteEvent(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return c.Write(body)
+}
+
+// Close disconnects the client. The writer goes on until it reported every
+// delivery queued before, without a writer they are reported here.
+func (c *Conn) Close() {
+	c.mu.Lock()
+	c.close()
+	c.mu.Unlock()
+
+	if c.ws != nil {
+		return
+	}
+	for msg := range c.out {
+		if msg.member != nil {
+			msg.member.sub.written(msg.member, *msg.delivery, ErrConnClosed)
+		}
+	}
+}
+
+// close is Close for callers holding c.mu.
+func (c *Conn) close() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.out)
+	if c.ws != nil {
+		_ = c.ws.Close()
+	}
+}
+
+// NewHTTPConn returns a connection read with Next.
+func NewHTTPConn() *Conn {
+	return &Conn{out: make(chan message, connBuffer)}
+}
+
+// NewConn starts writing to ws.
+func NewConn(ws *websocket.Conn) *Conn {
+	c := &Conn{
+		ws:  ws,
+		out: make(chan message, connBuffer),
+	}
+	go c.writeLoop()
+	return c
+}
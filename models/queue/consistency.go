@@ -0,0 +1,82 @@
+package models
+
+const (
+	// ConsistencyOne confirms a push once the leader stored it, before it
+	// is applied, so the pushed item comes back as it was sent.
+	ConsistencyOne = "one"
+	// ConsistencyQuorum confirms a push once a majority of replicas
+	// stored it.
+	ConsistencyQuorum = "quorum"
+	// ConsistencyAll confirms a push once every replica stored it.
+	ConsistencyAll = "all"
+)
+
+// ValidConsistency reports whether level is a consistency level, empty
+// meaning the default one.
+func ValidConsistency(level string) bool {
+	switch level {
+	case "", ConsistencyOne, ConsistencyQuorum, ConsistencyAll:
+		return true
+	}
+	return false
+}
+
+// Consistency returns how many replicas confirm a push into the queue,
+// empty for the default level.
+func (q *Queue) Consistency() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.consistency
+}
+
+// SetConsistency changes how many replicas confirm a push into the queue.
+func (q *Queue) SetConsistency(level string) error {
+	if !ValidConsistency(level) {
+		return ErrInvalidConsistency
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.consistency = level
+	return nil
+}
+
+// Revoke takes back pushed items that are still queued or scheduled, and
+// their record in the log of consumer groups, so a push too few replicas
+// confirmed does not linger. Items are matched by
+// key and enqueue time, leaving alone a later item with the same key. It
+// returns the items it took back, consumers may have the others already.
+func (q *Queue) Revoke(items []Data) []Data {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	revoked := make([]Data, 0)
+	for _, d := range items {
+		// Groups that did not read the item yet never will.
+		q.unrecord(d)
+
+		var found bool
+		if e, ok := q.elements[d.Key]; ok && e.Value.(Data).EnqueuedAt.Equal(d.EnqueuedAt) {
+			q.remove(e)
+			found = true
+		} else if s, ok := q.delayedKeys[d.Key]; ok && s.data.EnqueuedAt.Equal(d.EnqueuedAt) {
+			found = q.unschedule(d.Key)
+		}
+		if !found {
+			continue
+		}
+		delete(q.KeySet, d.Key)
+
+		// A retry of the push should go through, not return the
+		// revoked item.
+		id := d.DedupID()
+		if e, ok := q.seen[id]; ok {
+			q.seenOrder.Remove(e)
+			delete(q.seen, id)
+		}
+		revoked = append(revoked, d)
+	}
+	return revoked
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"container/heap"
+	"time"
+)
+
+// deadlineSlack is how many stale entries a deadline heap may hold beyond
+// twice its live ones before it is rebuilt.
+const deadlineSlack = 64
+
+// deadline is when the item or lease under key is due.
+type deadline struct {
+	at  time.Time
+	key string
+}
+
+// deadlines is a heap of deadlines, the earliest on top. Entries are not
+// removed when their item or lease goes away, they go stale and are
+// dropped once they reach the top.
+type deadlines []deadline
+
+func (d deadlines) Len() int { return len(d) }
+
+func (d deadlines) Less(i, j int) bool {
+	if d[i].at.Equal(d[j].at) {
+		return d[i].key < d[j].key
+	}
+	return d[i].at.Before(d[j].at)
+}
+
+func (d deadlines) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+
+func (d *deadlines) Push(x interface{}) {
+	*d = append(*d, x.(deadline))
+}
+
+func (d *deadlines) Pop() interface{} {
+	old := *d
+	item := old[len(old)-1]
+	*d = old[:len(old)-1]
+	return item
+}
+
+// track remembers when a queued, scheduled or dead-letter item expires.
+// Callers should hold q.mu.
+func (q *Queue) track(d Data) {
+	if d.ExpiresAt == nil {
+		return
+	}
+	q.expiring[d.Key] = *d.ExpiresAt
+	heap.Push(&q.expiries, deadline{at: *d.ExpiresAt, key: d.Key})
+
+	if len(q.expiries) > 2*len(q.expiring)+deadlineSlack {
+		q.expiries = make(deadlines, 0, len(q.expiring))
+		for key, at := range q.expiring {
+			q.expiries = append(q.expiries, deadline{at: at, key: key})
+		}
+		heap.Init(&q.expiries)
+	}
+}
+
+// untrack forgets the expiry of the item with key once it leaves the
+// queue, the schedule or the dead-letter queue. Callers should hold q.mu.
+func (q *Queue) untrack(key string) {
+	delete(q.expiring, key)
+}
+
+// nextExpiry returns the earliest expiry of a queued, scheduled or
+// dead-letter item. Callers should hold q.mu.
+func (q *Queue) nextExpiry() (deadline, bool) {
+	for len(q.expiries) > 0 {
+		top := q.expiries[0]
+		if at, ok := q.expiring[top.key]; ok && at.Equal(top.at) {
+			return top, true
+		}
+		heap.Pop(&q.expiries)
+	}
+	return deadline{}, false
+}
+
+// trackLease remembers when the lease l ends. Callers should hold q.mu.
+func (q *Queue) trackLease(l Lease) {
+	heap.Push(&q.leases, deadline{at: l.Deadline, key: l.Receipt})
+
+	if len(q.leases) > 2*len(q.InFlight)+deadlineSlack {
+		q.leases = make(deadlines, 0, len(q.InFlight))
+		for receipt, l := range q.InFlight {
+			q.leases = append(q.leases, deadline{at: l.Deadline, key: receipt})
+		}
+		heap.Init(&q.leases)
+	}
+}
+
+// nextLease returns the in-flight lease ending first. Callers should hold
+// q.mu.
+func (q *Queue) nextLease() (Lease, bool) {
+	for len(q.leases) > 0 {
+		top := q.leases[0]
+		if l, ok := q.InFlight[top.key]; ok && l.Deadline.Equal(top.at) {
+			return l, true
+		}
+		heap.Pop(&q.leases)
+	}
+	return Lease{}, false
+}
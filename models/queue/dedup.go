@@ -0,0 +1,73 @@
+package models
+
+import "time"
+
+// Seen is an item pushed within the deduplication window, remembered by
+// its idempotency key so a retry gets the original item back.
+type Seen struct {
+	ID   string    `json:"id"`
+	Data Data      `json:"data"`
+	At   time.Time `json:"at"`
+}
+
+// DedupID is what retries of the item are recognized by, its idempotency
+// key or else its key.
+func (d Data) DedupID() string {
+	if d.IdempotencyKey != "" {
+		return d.IdempotencyKey
+	}
+	return d.Key
+}
+
+// PushOnce pushes data unless an item with the same idempotency key was
+// pushed within window before now, in which case that item is returned.
+// Zero window pushes like Push.
+func (q *Queue) PushOnce(data Data, now time.Time, window time.Duration) (Data, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.forget(now, window)
+	d, _, err := q.pushOnce(data, now, window)
+	return d, err
+}
+
+// pushOnce is PushOnce without forgetting old items, also telling whether
+// data was a retry. Callers should hold q.mu.
+func (q *Queue) pushOnce(data Data, now time.Time, window time.Duration) (Data, bool, error) {
+	if window <= 0 {
+		return data, false, q.push(data)
+	}
+
+	id := data.DedupID()
+	if e, ok := q.seen[id]; ok {
+		return e.Value.(Seen).Data, true, nil
+	}
+	if err := q.push(data); err != nil {
+		return Data{}, false, err
+	}
+	q.seen[id] = q.seenOrder.PushBack(Seen{ID: id, Data: data, At: now})
+	return data, false, nil
+}
+
+// forget drops items seen longer than window before now. Callers should
+// hold q.mu.
+func (q *Queue) forget(now time.Time, window time.Duration) {
+	for e := q.seenOrder.Front(); e != nil; e = q.seenOrder.Front() {
+		s := e.Value.(Seen)
+		if window > 0 && s.At.Add(window).After(now) {
+			return
+		}
+		q.seenOrder.Remove(e)
+		delete(q.seen, s.ID)
+	}
+}
+
+// seenList returns remembered items, the oldest first. Callers should hold
+// q.mu.
+func (q *Queue) seenList() []Seen {
+	l := make([]Seen, 0, q.seenOrder.Len())
+	for e := q.seenOrder.Front(); e != nil; e = e.Next() {
+		l = append(l, e.Value.(Seen))
+	}
+	return l
+}
@@ -0,0 +1,201 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"time"
+)
+
+// DigestBuckets is how many buckets the items of a queue are hashed into.
+const DigestBuckets = 64
+
+// Digest sums up a queue so replicas can compare it without exchanging it.
+// Like leaves of a Merkle tree, items are hashed by key into buckets and
+// the buckets into the root, so replicas that differ only look closer at
+// the keys of the buckets that differ.
+type Digest struct {
+	Root string `json:"root"`
+	// State covers everything but the items, like the order of queued
+	// items, consumer groups and items remembered for deduplication.
+	State string `json:"state"`
+	// Buckets are empty for buckets without items.
+	Buckets []string `json:"buckets"`
+	Items   int      `json:"items"`
+}
+
+// itemState is what an item is hashed as, along with where it is.
+type itemState struct {
+	Where string `json:"where"`
+	Data  Data   `json:"data"`
+	// Receipt and Deadline are set for leased items.
+	Receipt  string `json:"receipt,omitempty"`
+	Deadline string `json:"deadline,omitempty"`
+}
+
+// Digest returns the digest of the queue.
+func (q *Queue) Digest() (Digest, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items, order, err := q.hashItems(nil)
+	if err != nil {
+		return Digest{}, err
+	}
+
+	state, err := hashJSON(struct {
+		Mode        string            `json:"mode"`
+		Consistency string            `json:"consistency"`
+		Order       []string          `json:"order"`
+		Seen        []Seen            `json:"seen"`
+		Records     []Record          `json:"records"`
+		NextOffset  uint64            `json:"nextOffset"`
+		Groups      map[string]uint64 `json:"groups"`
+	}{q.mode, q.consistency, order, q.seenList(), q.records, q.nextOffset, q.groups})
+	if err != nil {
+		return Digest{}, err
+	}
+
+	keys := make([][]string, DigestBuckets)
+	for k := range items {
+		b := Bucket(k)
+		keys[b] = append(keys[b], k)
+	}
+	d := Digest{State: state, Buckets: make([]string, DigestBuckets), Items: len(items)}
+	root := sha256.New()
+	root.Write([]byte(state))
+	for b, ks := range keys {
+		if len(ks) > 0 {
+			sort.Strings(ks)
+			h := sha256.New()
+			for _, k := range ks {
+				h.Write([]byte(k + "\x00" + items[k] + "\n"))
+			}
+			d.Buckets[b] = hex.EncodeToString(h.Sum(nil))
+		}
+		root.Write([]byte(d.Buckets[b] + "\n"))
+	}
+	d.Root = hex.EncodeToString(root.Sum(nil))
+	return d, nil
+}
+
+// BucketItems returns the hash of every item in buckets by its key.
+func (q *Queue) BucketItems(buckets []int) (map[string]string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	in := make(map[int]bool, len(buckets))
+	for _, b := range buckets {
+		in[b] = true
+	}
+	items, _, err := q.hashItems(func(key string) bool { return in[Bucket(key)] })
+	return items, err
+}
+
+// hashItems returns the hash of every item pick picks, or of every item if
+// pick is nil, by its key and the keys of queued items in order. Callers
+// should hold q.mu.
+func (q *Queue) hashItems(pick func(key string) bool) (map[string]string, []string, error) {
+	items := make(map[string]string)
+	add := func(s itemState) error {
+		if pick != nil && !pick(s.Data.Key) {
+			return nil
+		}
+		h, err := hashJSON(s)
+		if err != nil {
+			return err
+		}
+		items[s.Data.Key] = h
+		return nil
+	}
+
+	list := q.list()
+	order := make([]string, 0, len(list))
+	for _, d := range list {
+		order = append(order, d.Key)
+		if err := add(itemState{Where: "queued", Data: d}); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, d := range q.scheduledList() {
+		if err := add(itemState{Where: "scheduled", Data: d}); err != nil {
+			return nil, nil, err
+		}
+	}
+	for receipt, l := range q.InFlight {
+		deadline, _ := l.Deadline.MarshalText()
+		if err := add(itemState{Where: "leased", Data: l.Data, Receipt: receipt, Deadline: string(deadline)}); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, d := range q.DeadLetter {
+		if err := add(itemState{Where: "dead", Data: d}); err != nil {
+			return nil, nil, err
+		}
+	}
+	return items, order, nil
+}
+
+// Diff returns the buckets that differ between the digests.
+func (d Digest) Diff(o Digest) []int {
+	diff := make([]int, 0)
+	for b := 0; b < DigestBuckets; b++ {
+		if bucketAt(d.Buckets, b) != bucketAt(o.Buckets, b) {
+			diff = append(diff, b)
+		}
+	}
+	return diff
+}
+
+// Bucket returns the bucket the item with key is hashed into.
+func Bucket(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % DigestBuckets)
+}
+
+func bucketAt(buckets []string, b int) string {
+	if b < len(buckets) {
+		return buckets[b]
+	}
+	return ""
+}
+
+func hashJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Reconciliation sums up the anti-entropy rounds of the leader, which
+// compare replicas with it and repair the ones that diverged.
+type Reconciliation struct {
+	Rounds    int       `json:"rounds"`
+	LastRound time.Time `json:"lastRound,omitempty"`
+	// Divergences counts replicas found diverged over every round, and
+	// Repairs the ones repaired.
+	Divergences int `json:"divergences"`
+	Repairs     int `json:"repairs"`
+	Failures    int `json:"failures"`
+	// Replicas holds the outcome of the last round for each replica.
+	Replicas map[string]ReplicaReport `json:"replicas"`
+}
+
+// ReplicaReport is how a replica compared with the leader.
+type ReplicaReport struct {
+	Diverged bool `json:"diverged"`
+	// Queues are the ones that differ.
+	Queues []string `json:"queues,omitempty"`
+	// Missing items are on the leader only, Extra on the replica only and
+	// Stale on both but not the same.
+	Missing  int    `json:"missing"`
+	Stale    int    `json:"stale"`
+	Extra    int    `json:"extra"`
+	Repaired bool   `json:"repaired"`
+	Error    string `json:"error,omitempty"`
+}
@@ -0,0 +1,57 @@
+package models
+
+// Redrive moves the dead-letter item with key, or every one of them if key
+// is empty, back to the tail of the queue with a fresh delivery counter.
+func (q *Queue) Redrive(key string) ([]Data, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	moved, err := q.takeDeadLetter(key)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range moved {
+		moved[i].Deliveries = 0
+		q.pushBack(moved[i])
+	}
+	return moved, nil
+}
+
+// Purge drops the dead-letter item with key, or every one of them if key
+// is empty.
+func (q *Queue) Purge(key string) ([]Data, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	purged, err := q.takeDeadLetter(key)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range purged {
+		delete(q.KeySet, d.Key)
+	}
+	return purged, nil
+}
+
+// takeDeadLetter removes dead-letter items. Callers should hold q.mu.
+func (q *Queue) takeDeadLetter(key string) ([]Data, error) {
+	if key == "" {
+		taken := q.DeadLetter
+		q.DeadLetter = make([]Data, 0)
+		for _, d := range taken {
+			q.untrack(d.Key)
+		}
+		return taken, nil
+	}
+
+	for i, d := range q.DeadLetter {
+		if d.Key == key {
+			q.DeadLetter = append(q.DeadLetter[:i:i], q.DeadLetter[i+1:]...)
+			q.untrack(key)
+			return []Data{d}, nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
@@ -7,5 +7,38 @@ var ErrEmptyList = errors.New("Queue is empty")
 var ErrParseData = errors.New("Can not parse input data")
 var ErrKeyNotFound = errors.New("Key not found")
 var ErrObjectNotFound = errors.New("Object not found")
+var ErrReceiptNotFound = errors.New("Receipt not found or lease expired")
+var ErrInvalidPriority = errors.New("Priority should be an integer")
+var ErrInvalidDelay = errors.New("Delay should be a non-negative duration and deliverAt an RFC 3339 time")
+var ErrInvalidTTL = errors.New("TTL should be a positive duration")
+var ErrInvalidBatch = errors.New("Batch size should be positive and not more than the limit")
+var ErrInvalidFilter = errors.New("Key pattern should be a valid glob and headers name:value pairs")
+var ErrInvalidWait = errors.New("Wait should be a positive duration")
+
+var ErrQueueExist = errors.New("Queue already exists")
+var ErrQueueNotFound = errors.New("Queue not found")
+var ErrInvalidQueueName = errors.New("Queue name should be 1 to 64 letters, digits, '-', '_' or '.'")
+var ErrDefaultQueue = errors.New("Default queue can not be deleted")
+
+var ErrGroupExist = errors.New("Consumer group already exists")
+var ErrGroupNotFound = errors.New("Consumer group not found")
+var ErrInvalidGroupName = errors.New("Group name should be 1 to 64 letters, digits, '-', '_' or '.'")
+var ErrInvalidOffset = errors.New("Offset should be earliest, latest or one retained in the log")
+
+var ErrInvalidMode = errors.New("Mode should be random, round-robin or broadcast")
+var ErrInvalidConsistency = errors.New("Consistency should be one, quorum or all")
+var ErrNotConfirmed = errors.New("Too few replicas confirmed the push in time, it is rolled back")
 
 var ErrSubscriberExist = errors.New("You already subscribed")
+var ErrNoSubscriber = errors.New("No subscriber to send to")
+var ErrNotSubscribed = errors.New("You did not subscribe")
+var ErrInvalidCredit = errors.New("Credit should be a positive number")
+var ErrExpired = errors.New("Item expired before it was sent")
+var ErrUnknownMessage = errors.New("Message not found or already settled")
+var ErrRejected = errors.New("Subscriber rejected the item")
+var ErrConnClosed = errors.New("Connection is closed")
+
+var ErrInvalidCommand = errors.New("Command is not valid")
+var ErrHandshakeRequired = errors.New("Say hello before any other command")
+var ErrUnsupportedVersion = errors.New("None of the protocol versions is supported")
+var ErrCheckpointNotFound = errors.New("Checkpoint is not applied on this replica")
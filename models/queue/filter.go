@@ -0,0 +1,92 @@
+package models
+
+import (
+	"container/list"
+	"path"
+	"strings"
+	"time"
+)
+
+// Filter picks the items a consumer is handed. Empty fields match any
+// item and headers match if the item has each of them with that value.
+// KeyPattern is a glob like path.Match takes, so '*' stops at '/'.
+type Filter struct {
+	KeyPrefix   string            `json:"keyPrefix,omitempty"`
+	KeyPattern  string            `json:"keyPattern,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Producer    string            `json:"producer,omitempty"`
+}
+
+// Empty reports whether f matches every item.
+func (f Filter) Empty() bool {
+	return f.KeyPrefix == "" && f.KeyPattern == "" && len(f.Headers) == 0 &&
+		f.ContentType == "" && f.Producer == ""
+}
+
+// Validate reports whether the key pattern of f is a valid glob.
+func (f Filter) Validate() error {
+	if _, err := path.Match(f.KeyPattern, ""); err != nil {
+		return ErrInvalidFilter
+	}
+	return nil
+}
+
+// Match reports whether f picks d.
+func (f Filter) Match(d Data) bool {
+	if !strings.HasPrefix(d.Key, f.KeyPrefix) {
+		return false
+	}
+	if f.KeyPattern != "" {
+		if ok, _ := path.Match(f.KeyPattern, d.Key); !ok {
+			return false
+		}
+	}
+	if f.ContentType != "" && f.ContentType != d.ContentType {
+		return false
+	}
+	if f.Producer != "" && f.Producer != d.Producer {
+		return false
+	}
+	for k, v := range f.Headers {
+		if h, ok := d.Headers[k]; !ok || h != v {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchAny reports whether one of filters picks d. No filters pick any
+// item.
+func MatchAny(filters []Filter, d Data) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, f := range filters {
+		if f.Match(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns the first item alive at now one of filters picks, head of
+// the queue if there are no filters and nil if there is none. Items
+// skipped stay where they are, so finding one takes as long as the items
+// ahead of it. Callers should hold q.mu.
+func (q *Queue) match(now time.Time, filters []Filter) *list.Element {
+	e := q.head(now)
+	if e == nil || MatchAny(filters, e.Value.(Data)) {
+		return e
+	}
+
+	for _, p := range q.priorities {
+		for e := q.levels[p].Front(); e != nil; e = e.Next() {
+			d := e.Value.(Data)
+			if !d.Expired(now) && MatchAny(filters, d) {
+				return e
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package models
+
+import "sort"
+
+// Consumer groups read a log of every item pushed to the queue, each from
+// its own offset, so every group sees every item while the consumers of
+// one group share them. The log is independent from the queue itself:
+// pulling, leasing or acking items does not change it. It only keeps items
+// pushed while the queue has a group, so queues without groups hold no
+// copy of their items.
+
+// OffsetEarliest and OffsetLatest move a group to the oldest item retained
+// in the log or past the newest one.
+const (
+	OffsetEarliest = "earliest"
+	OffsetLatest   = "latest"
+)
+
+// Record is an item in the log of the queue.
+type Record struct {
+	Offset uint64 `json:"offset"`
+	Data   Data   `json:"data"`
+}
+
+// Group is a consumer group with the offset of the next record it reads.
+type Group struct {
+	Name   string `json:"name"`
+	Offset uint64 `json:"offset"`
+	// Lag is how many records the group did not read yet.
+	Lag uint64 `json:"lag"`
+}
+
+// Trim drops the oldest records so at most retention of them are kept.
+// Zero retention keeps every record.
+func (q *Queue) Trim(retention int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if retention <= 0 || len(q.records) <= retention {
+		return
+	}
+	// Reslicing is enough, append copies live records alone once the
+	// backing array fills up.
+	q.records = q.records[len(q.records)-retention:]
+}
+
+func (q *Queue) CreateGroup(name string, from string) (Group, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.groups[name]; ok {
+		return Group{}, ErrGroupExist
+	}
+	if from == "" {
+		from = OffsetLatest
+	}
+
+	g, err := q.resetGroup(name, from, 0)
+	if err != nil {
+		delete(q.groups, name)
+	}
+	return g, err
+}
+
+func (q *Queue) DeleteGroup(name string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.groups[name]; !ok {
+		return ErrGroupNotFound
+	}
+	delete(q.groups, name)
+	if len(q.groups) == 0 {
+		q.records = nil
+	}
+	return nil
+}
+
+// ResetGroup moves the group to offset, or to the earliest or latest one
+// if from says so.
+func (q *Queue) ResetGroup(name string, from string, offset uint64) (Group, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.groups[name]; !ok {
+		return Group{}, ErrGroupNotFound
+	}
+	return q.resetGroup(name, from, offset)
+}
+
+// Groups returns every group of the queue sorted by name.
+func (q *Queue) Groups() []Group {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	groups := make([]Group, 0, len(q.groups))
+	for name := range q.groups {
+		groups = append(groups, q.group(name))
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+// HasGroup reports whether the group exists.
+func (q *Queue) HasGroup(name string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, ok := q.groups[name]
+	return ok
+}
+
+// Fetch returns up to n records the group did not read yet, without
+// moving its offset.
+func (q *Queue) Fetch(name string, n int) ([]Record, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	offset, ok := q.groups[name]
+	if !ok {
+		return nil, ErrGroupNotFound
+	}
+	return q.fetch(offset, n), nil
+}
+
+// Consume returns up to n records the group did not read yet and moves its
+// offset past them.
+func (q *Queue) Consume(name string, n int) ([]Record, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	offset, ok := q.groups[name]
+	if !ok {
+		return nil, ErrGroupNotFound
+	}
+
+	records := q.fetch(offset, n)
+	if len(records) > 0 {
+		q.groups[name] = records[len(records)-1].Offset + 1
+	}
+	return records, nil
+}
+
+// Commit moves the group past offset unless it already is.
+func (q *Queue) Commit(name string, offset uint64) (Group, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	current, ok := q.groups[name]
+	if !ok {
+		return Group{}, ErrGroupNotFound
+	}
+	if offset+1 > current && offset < q.nextOffset {
+		q.groups[name] = offset + 1
+	}
+	return q.group(name), nil
+}
+
+// record appends d to the log if a group may read it. Callers should
+// hold q.mu.
+func (q *Queue) record(d Data) {
+	if len(q.groups) > 0 {
+		q.records = append(q.records, Record{Offset: q.nextOffset, Data: d})
+	}
+	q.nextOffset++
+}
+
+// unrecord drops the record of d from the log, for a push taken back.
+// Records are matched like items are, by key and enqueue time. Callers
+// should hold q.mu.
+func (q *Queue) unrecord(d Data) {
+	for i := len(q.records) - 1; i >= 0; i-- {
+		r := q.records[i].Data
+		if r.Key == d.Key && r.EnqueuedAt.Equal(d.EnqueuedAt) {
+			q.records = append(q.records[:i:i], q.records[i+1:]...)
+			return
+		}
+	}
+}
+
+// index returns the position of the first record at offset or past it,
+// revoked records leaving gaps between offsets. Callers should hold q.mu.
+func (q *Queue) index(offset uint64) int {
+	return sort.Search(len(q.records), func(i int) bool { return q.records[i].Offset >= offset })
+}
+
+// firstOffset is the offset of the oldest record retained. Callers should
+// hold q.mu.
+func (q *Queue) firstOffset() uint64 {
+	if len(q.records) == 0 {
+		return q.nextOffset
+	}
+	return q.records[0].Offset
+}
+
+// fetch returns up to n records from offset on, skipping the trimmed and
+// revoked ones. Callers should hold q.mu.
+func (q *Queue) fetch(offset uint64, n int) []Record {
+	i := q.index(offset)
+	if i >= len(q.records) {
+		return []Record{}
+	}
+	end := i + n
+	if end > len(q.records) {
+		end = len(q.records)
+	}
+	return append([]Record(nil), q.records[i:end]...)
+}
+
+// resetGroup is ResetGroup for a group known to exist. Callers should hold
+// q.mu.
+func (q *Queue) resetGroup(name string, from string, offset uint64) (Group, error) {
+	switch from {
+	case OffsetEarliest:
+		offset = q.firstOffset()
+	case OffsetLatest:
+		offset = q.nextOffset
+	case "":
+		if offset < q.firstOffset() || offset > q.nextOffset {
+			return Group{}, ErrInvalidOffset
+		}
+	default:
+		return Group{}, ErrInvalidOffset
+	}
+	q.groups[name] = offset
+	return q.group(name), nil
+}
+
+// group describes the group called name. Callers should hold q.mu.
+func (q *Queue) group(name string) Group {
+	offset := q.groups[name]
+	if first := q.firstOffset(); offset < first {
+		offset = first
+	}
+	return Group{Name: name, Offset: offset, Lag: uint64(len(q.records) - q.index(offset))}
+}
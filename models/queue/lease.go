@@ -0,0 +1,146 @@
+package models
+
+import (
+	"container/heap"
+	"container/list"
+	"time"
+)
+
+// Lease is an item handed to a consumer that stays hidden from others
+// until the consumer acks it or the deadline passes.
+type Lease struct {
+	Data     Data      `json:"data"`
+	Receipt  string    `json:"receipt"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// Lease moves head of the queue to in-flight items under receipt and
+// counts it as delivered once more. Items expired at now are dropped. With
+// filters it leases the first item one of them picks instead.
+func (q *Queue) Lease(receipt string, now, deadline time.Time, filters ...Filter) (Lease, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.lease(receipt, now, deadline, filters)
+}
+
+func (q *Queue) lease(receipt string, now, deadline time.Time, filters []Filter) (Lease, error) {
+	e := q.match(now, filters)
+	if e == nil {
+		return Lease{}, ErrEmptyList
+	}
+	return q.leaseElement(e, receipt, deadline), nil
+}
+
+// leaseElement moves the queued item e to in-flight items under receipt.
+// Callers should hold q.mu.
+func (q *Queue) leaseElement(e *list.Element, receipt string, deadline time.Time) Lease {
+	d := q.remove(e)
+	d.Deliveries++
+	l := Lease{
+		Data:     d,
+		Receipt:  receipt,
+		Deadline: deadline,
+	}
+	q.InFlight[receipt] = l
+	q.leased[d.Key] = receipt
+	q.trackLease(l)
+	return l
+}
+
+// Ack removes a leased item for good.
+func (q *Queue) Ack(receipt string) (Data, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	l, ok := q.InFlight[receipt]
+	if !ok {
+		return Data{}, ErrReceiptNotFound
+	}
+
+	delete(q.InFlight, receipt)
+	delete(q.leased, l.Data.Key)
+	delete(q.KeySet, l.Data.Key)
+	return l.Data, nil
+}
+
+// Release gives a leased item back before its deadline. It goes to the
+// head of the queue, or to the dead-letter queue once it was delivered
+// maxDeliveries times. Zero maxDeliveries never dead-letters.
+func (q *Queue) Release(receipt string, maxDeliveries int) (Data, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	l, ok := q.InFlight[receipt]
+	if !ok {
+		return Data{}, ErrReceiptNotFound
+	}
+
+	q.requeue([]Lease{l}, maxDeliveries)
+	return l.Data, nil
+}
+
+// Return gives a leased item back to the head of the queue as if it was
+// never leased, for an item that could not be handed to anyone.
+func (q *Queue) Return(receipt string) (Data, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	l, ok := q.InFlight[receipt]
+	if !ok {
+		return Data{}, ErrReceiptNotFound
+	}
+
+	l.Data.Deliveries--
+	q.requeue([]Lease{l}, 0)
+	return l.Data, nil
+}
+
+// Expired reports whether any lease has passed its deadline at now.
+func (q *Queue) Expired(now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	next, ok := q.nextLease()
+	return ok && !next.Deadline.After(now)
+}
+
+// Expire releases every item whose lease passed its deadline at now, the
+// earliest deadline ending up first.
+func (q *Queue) Expire(now time.Time, maxDeliveries int) []Lease {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	expired := make([]Lease, 0)
+	for {
+		next, ok := q.nextLease()
+		if !ok || next.Deadline.After(now) {
+			break
+		}
+		heap.Pop(&q.leases)
+		expired = append(expired, next)
+	}
+
+	q.requeue(expired, maxDeliveries)
+	return expired
+}
+
+// requeue puts leases back to the head of the queue in order, except the
+// ones out of delivery attempts which go to the dead-letter queue.
+// Callers should hold q.mu.
+func (q *Queue) requeue(leases []Lease, maxDeliveries int) {
+	head := make([]Data, 0, len(leases))
+	for _, l := range leases {
+		delete(q.InFlight, l.Receipt)
+		delete(q.leased, l.Data.Key)
+		if maxDeliveries > 0 && l.Data.Deliveries >= maxDeliveries {
+			q.DeadLetter = append(q.DeadLetter, l.Data)
+			q.track(l.Data)
+			continue
+		}
+		head = append(head, l.Data)
+	}
+	for i := len(head) - 1; i >= 0; i-- {
+		q.pushFront(head[i])
+	}
+}
@@ -0,0 +1,13 @@
+package models
+
+import "regexp"
+
+// DefaultQueue is the queue served by the endpoints without a queue name.
+const DefaultQueue = "default"
+
+var queueName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// ValidQueueName reports whether name can be used for a queue.
+func ValidQueueName(name string) bool {
+	return queueName.MatchString(name)
+}
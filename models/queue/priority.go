@@ -0,0 +1,79 @@
+package models
+
+import (
+	"container/list"
+	"sort"
+)
+
+// Items of higher priority are served first and items of one priority in
+// the order they arrived. Each priority keeps its own list and priorities
+// lists the ones holding items, highest first.
+
+// level returns the list of items with priority p, creating it if needed.
+// Callers should hold q.mu.
+func (q *Queue) level(p int) *list.List {
+	if l, ok := q.levels[p]; ok {
+		return l
+	}
+
+	l := list.New()
+	q.levels[p] = l
+	i := sort.Search(len(q.priorities), func(i int) bool { return q.priorities[i] < p })
+	q.priorities = append(q.priorities, 0)
+	copy(q.priorities[i+1:], q.priorities[i:])
+	q.priorities[i] = p
+	return l
+}
+
+// pushBack queues d behind the items of its priority. Callers should hold
+// q.mu.
+func (q *Queue) pushBack(d Data) {
+	q.elements[d.Key] = q.level(d.Priority).PushBack(d)
+	q.track(d)
+}
+
+// pushFront queues d ahead of the items of its priority. Callers should
+// hold q.mu.
+func (q *Queue) pushFront(d Data) {
+	q.elements[d.Key] = q.level(d.Priority).PushFront(d)
+	q.track(d)
+}
+
+// front returns head of the queue, nil if it is empty. Callers should hold
+// q.mu.
+func (q *Queue) front() *list.Element {
+	if len(q.priorities) == 0 {
+		return nil
+	}
+	return q.levels[q.priorities[0]].Front()
+}
+
+// remove takes a queued item out of its list, leaving its key in the key
+// set. Callers should hold q.mu.
+func (q *Queue) remove(e *list.Element) Data {
+	d := e.Value.(Data)
+	l := q.levels[d.Priority]
+	l.Remove(e)
+	delete(q.elements, d.Key)
+	q.untrack(d.Key)
+
+	if l.Len() == 0 {
+		delete(q.levels, d.Priority)
+		for i, p := range q.priorities {
+			if p == d.Priority {
+				q.priorities = append(q.priorities[:i], q.priorities[i+1:]...)
+				break
+			}
+		}
+	}
+	return d
+}
+
+// walk calls f with queued items in order. Callers should hold q.mu.
+func (q *Queue) walk(f func(d Data)) {
+	for _, p := range q.priorities {
+		for e := q.levels[p].Front(); e != nil; e = e.Next() {
+			f(e.Value.(Data))
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package models
+
+// Clients of the subscribe endpoint either send the plain text
+// "subscribe" and get bare items back, or speak the JSON protocol. A JSON
+// client opens with a hello listing the versions it speaks and the server
+// welcomes it with the highest one both know. After that every message is
+// a Command from the client or an Event from the server.
+
+// ProtocolVersions are the versions of the JSON protocol the server
+// speaks, oldest first.
+var ProtocolVersions = []int{1}
+
+// Commands a client sends.
+const (
+	CommandHello       = "hello"
+	CommandSubscribe   = "subscribe"
+	CommandUnsubscribe = "unsubscribe"
+	CommandAck         = "ack"
+	CommandNack        = "nack"
+	CommandCredit      = "credit"
+	CommandPing        = "ping"
+)
+
+// Events the server sends.
+const (
+	EventWelcome = "welcome"
+	EventOK      = "ok"
+	EventError   = "error"
+	EventPong    = "pong"
+	EventMessage = "message"
+)
+
+// Ack modes of a subscription. Auto acks items once written, manual waits
+// for the client to ack or nack each one.
+const (
+	AckAuto   = "auto"
+	AckManual = "manual"
+)
+
+// Command is a message of a client. Ref is chosen by the client and echoed
+// by the event answering the command.
+type Command struct {
+	Type string `json:"type"`
+	Ref  string `json:"ref,omitempty"`
+	// Versions are the ones the client speaks, for hello.
+	Versions []int `json:"versions,omitempty"`
+	// Queue and Group address the subscription, the queue of the endpoint
+	// if empty.
+	Queue  string `json:"queue,omitempty"`
+	Group  string `json:"group,omitempty"`
+	Filter Filter `json:"filter,omitempty"`
+	Ack    string `json:"ack,omitempty"`
+	// ID is the message to ack or nack.
+	ID     string `json:"id,omitempty"`
+	Credit int    `json:"credit,omitempty"`
+}
+
+// Event is a message of the server. Message events carry the ID the item
+// is acked with, error events a Code clients can rely on and a Message
+// for people.
+type Event struct {
+	Type     string `json:"type"`
+	Ref      string `json:"ref,omitempty"`
+	Version  int    `json:"version,omitempty"`
+	Versions []int  `json:"versions,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Queue    string `json:"queue,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Data     *Data  `json:"data,omitempty"`
+	Code     string `json:"code,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+// errorCodes are the codes of errors clients may want to tell apart.
+var errorCodes = map[error]string{
+	ErrParseData:          "invalid_json",
+	ErrInvalidCommand:     "invalid_command",
+	ErrHandshakeRequired:  "handshake_required",
+	ErrUnsupportedVersion: "unsupported_version",
+	ErrQueueNotFound:      "queue_not_found",
+	ErrInvalidQueueName:   "invalid_queue_name",
+	ErrGroupNotFound:      "group_not_found",
+	ErrInvalidGroupName:   "invalid_group_name",
+	ErrSubscriberExist:    "already_subscribed",
+	ErrNotSubscribed:      "not_subscribed",
+	ErrInvalidCredit:      "invalid_credit",
+	ErrInvalidFilter:      "invalid_filter",
+	ErrUnknownMessage:     "unknown_message",
+	ErrConnClosed:         "connection_closed",
+}
+
+// ErrorEvent answers the command ref with err.
+func ErrorEvent(ref string, err error) Event {
+	code, ok := errorCodes[err]
+	if !ok {
+		code = "internal_error"
+	}
+	return Event{Type: EventError, Ref: ref, Code: code, Message: err.Error()}
+}
+
+// NegotiateVersion returns the highest of versions the server speaks.
+func NegotiateVersion(versions []int) (int, error) {
+	for i := len(ProtocolVersions) - 1; i >= 0; i-- {
+		for _, v := range versions {
+			if v == ProtocolVersions[i] {
+				return v, nil
+			}
+		}
+	}
+	return 0, ErrUnsupportedVersion
+}
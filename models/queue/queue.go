@@ -1,136 +1,386 @@
 package models
 
 import (
+	"container/list"
 	"encoding/json"
-	"math/rand"
-
-	"github.com/gorilla/websocket"
+	"sync"
+	"time"
 )
 
-type Subscriber struct {
-	Member map[string]*websocket.Conn
-	List   []string
-}
-
 type Data struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
+	// Deliveries counts how many times the item was handed to a consumer.
+	Deliveries int `json:"deliveries"`
+	// Priority puts the item ahead of the ones with a lower priority.
+	Priority    int               `json:"priority,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Producer    string            `json:"producer,omitempty"`
+	EnqueuedAt  time.Time         `json:"enqueuedAt"`
+	// ExpiresAt is when the item is dropped if nobody consumed it, nil
+	// keeps it forever.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// DeliverAt holds the item back until then, nil delivers it at once.
+	DeliverAt *time.Time `json:"deliverAt,omitempty"`
+	// IdempotencyKey recognizes retries of the item, its key if empty.
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	// Sequence orders the item among every item accepted by the cluster.
+	Sequence uint64 `json:"sequence,omitempty"`
 }
 
 type Queue struct {
-	KeySet map[string]struct{} `json:"keySet"`
-	List   []Data              `json:"list"`
+	// mu guards every field below, so a queue is safe for concurrent use.
+	mu     sync.Mutex
+	KeySet map[string]struct{}
+	// mode is how items reach subscribers of the queue.
+	mode string
+	// consistency is how many replicas confirm a push into the queue.
+	consistency string
+	// levels holds queued items per priority and elements indexes them by
+	// key, so both ends and any key are reached in constant time.
+	levels     map[int]*list.List
+	priorities []int
+	elements   map[string]*list.Element
+	// delayed holds items until their delivery time, indexed by key in
+	// delayedKeys.
+	delayed     schedule
+	delayedKeys map[string]*scheduled
+	seq         uint64
+	// sequence is the highest sequence number of an item pushed into the
+	// queue.
+	sequence uint64
+	// seen remembers recently pushed items by idempotency key, oldest
+	// first in seenOrder.
+	seen      map[string]*list.Element
+	seenOrder *list.List
+	// records is the log consumer groups read, groups holds the offset
+	// of the next record each group reads.
+	records    []Record
+	nextOffset uint64
+	groups     map[string]uint64
+	// InFlight holds leased items by their receipt until they are acked
+	// or their lease expires.
+	InFlight map[string]Lease
+	// leased maps keys of in-flight items to their receipt.
+	leased map[string]string
+	// DeadLetter holds items that ran out of delivery attempts.
+	DeadLetter []Data
+	// expiries holds when queued, scheduled and dead-letter items expire
+	// and expiring the current expiry of each by key. leases holds when
+	// in-flight leases end. Due ones are found on top without a walk.
+	expiries deadlines
+	expiring map[string]time.Time
+	leases   deadlines
+}
+
+// queueJSON is how a queue looks on the wire and in snapshots.
+type queueJSON struct {
+	Mode        string              `json:"mode,omitempty"`
+	Consistency string              `json:"consistency,omitempty"`
+	KeySet      map[string]struct{} `json:"keySet"`
+	List        []Data              `json:"list"`
+	Scheduled   []Data              `json:"scheduled"`
+	InFlight    map[string]Lease    `json:"inFlight"`
+	DeadLetter  []Data              `json:"deadLetter"`
+	Seen        []Seen              `json:"seen"`
+	Records     []Record            `json:"records"`
+	NextOffset  uint64              `json:"nextOffset"`
+	Groups      map[string]uint64   `json:"groups"`
+	Sequence    uint64              `json:"sequence"`
 }
 
 func (q *Queue) Push(data Data) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.push(data)
+}
+
+func (q *Queue) push(data Data) error {
 	if _, ok := q.KeySet[data.Key]; ok {
 		return ErrKeyExist
 	}
 	q.KeySet[data.Key] = struct{}{}
 
-	q.List = append(q.List, data)
+	if data.DeliverAt != nil {
+		q.schedule(data)
+	} else {
+		q.pushBack(data)
+	}
+	q.record(data)
+	q.sequence = max(q.sequence, data.Sequence)
 	return nil
 }
 
-func (q *Queue) Pull() (Data, error) {
-	if len(q.List) == 0 {
+// Pull removes head of the queue, dropping items expired at now on the way.
+// With filters it removes the first item one of them picks instead.
+func (q *Queue) Pull(now time.Time, filters ...Filter) (Data, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e := q.match(now, filters)
+	if e == nil {
 		return Data{}, ErrEmptyList
 	}
 
-	delete(q.KeySet, q.List[0].Key)
-	result := q.List[0]
-	q.List = q.List[1:]
+	result := q.remove(e)
+	delete(q.KeySet, result.Key)
 	return result, nil
 }
 
+// Peek returns head of the queue without removing it, skipping items
+// expired at now. It changes nothing, so scheduled items due at now show
+// up once something else queued them.
+func (q *Queue) Peek(now time.Time) (Data, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, p := range q.priorities {
+		for e := q.levels[p].Front(); e != nil; e = e.Next() {
+			if d := e.Value.(Data); !d.Expired(now) {
+				return d, nil
+			}
+		}
+	}
+	return Data{}, ErrEmptyList
+}
+
+// Mode returns how items reach subscribers of the queue.
+func (q *Queue) Mode() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.mode == "" {
+		return ModeRandom
+	}
+	return q.mode
+}
+
+// SetMode changes how items reach subscribers of the queue.
+func (q *Queue) SetMode(mode string) error {
+	if !ValidMode(mode) {
+		return ErrInvalidMode
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.mode = mode
+	return nil
+}
+
+// Len returns the number of queued items, leaving out scheduled,
+// in-flight and dead-letter ones.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.elements)
+}
+
+// Sequence returns the highest sequence number of an item pushed into the
+// queue.
+func (q *Queue) Sequence() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.sequence
+}
+
 func (q *Queue) Delete(key string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
 	if _, ok := q.KeySet[key]; !ok {
 		return ErrKeyNotFound
 	}
 
-	delete(q.KeySet, key)
-	for i, l := range q.List {
-		if l.Key == key {
-			if i+1 == len(q.List) {
-				q.List = q.List[:i]
-			} else {
-				q.List = append(q.List[:i], q.List[i+1:]...)
-			}
-			return nil
-		}
+	if receipt, ok := q.leased[key]; ok {
+		delete(q.InFlight, receipt)
+		delete(q.leased, key)
+		delete(q.KeySet, key)
+		return nil
+	}
+	if e, ok := q.elements[key]; ok {
+		q.remove(e)
+		delete(q.KeySet, key)
+		return nil
+	}
+	if q.unschedule(key) {
+		delete(q.KeySet, key)
+		return nil
+	}
+	if _, err := q.takeDeadLetter(key); err == nil {
+		delete(q.KeySet, key)
+		return nil
 	}
 	return ErrObjectNotFound
 }
 
 func (q *Queue) BulkPush(data []byte) error {
-	var tmp Queue
+	var tmp queueJSON
 
 	err := json.Unmarshal(data, &tmp)
 	if err != nil {
 		return ErrParseData
 	}
 
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// Queued items stay queued even if they had a delivery time, only
+	// the scheduled ones wait for it.
 	for _, l := range tmp.List {
-		err := q.Push(l)
-		if err != nil {
-			return err
+		if _, ok := q.KeySet[l.Key]; ok {
+			return ErrKeyExist
+		}
+		q.KeySet[l.Key] = struct{}{}
+		q.pushBack(l)
+	}
+	for _, l := range tmp.Scheduled {
+		if _, ok := q.KeySet[l.Key]; ok || l.DeliverAt == nil {
+			return ErrParseData
+		}
+		q.KeySet[l.Key] = struct{}{}
+		q.schedule(l)
+	}
+	for receipt, l := range tmp.InFlight {
+		if _, ok := q.KeySet[l.Data.Key]; ok {
+			return ErrKeyExist
+		}
+		q.KeySet[l.Data.Key] = struct{}{}
+		q.InFlight[receipt] = l
+		q.leased[l.Data.Key] = receipt
+		q.trackLease(l)
+	}
+	for _, l := range tmp.DeadLetter {
+		if _, ok := q.KeySet[l.Key]; ok {
+			return ErrKeyExist
+		}
+		q.KeySet[l.Key] = struct{}{}
+		q.DeadLetter = append(q.DeadLetter, l)
+		q.track(l)
+	}
+	for _, s := range tmp.Seen {
+		if _, ok := q.seen[s.ID]; !ok {
+			q.seen[s.ID] = q.seenOrder.PushBack(s)
 		}
 	}
+	q.mode = tmp.Mode
+	q.consistency = tmp.Consistency
+	q.records = append(q.records, tmp.Records...)
+	if tmp.NextOffset > q.nextOffset {
+		q.nextOffset = tmp.NextOffset
+	}
+	for name, offset := range tmp.Groups {
+		q.groups[name] = offset
+	}
+	q.sequence = max(q.sequence, tmp.Sequence)
 	return nil
 }
 
-func NewQueue() *Queue {
-	return &Queue{
-		KeySet: make(map[string]struct{}),
-		List:   make([]Data, 0),
-	}
-}
+func (q *Queue) MarshalJSON() ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
 
-func (s *Subscriber) Subscribe(c *websocket.Conn, addr string) (string, error) {
-	if _, ok := s.Member[addr]; ok {
-		return "", ErrSubscriberExist
-	}
-	s.Member[addr] = c
-	s.List = append(s.List, addr)
-	return "You subscribe successfully", nil
+	return json.Marshal(queueJSON{
+		Mode:        q.mode,
+		Consistency: q.consistency,
+		KeySet:      q.KeySet,
+		List:        q.list(),
+		Scheduled:   q.scheduledList(),
+		InFlight:    q.InFlight,
+		DeadLetter:  q.DeadLetter,
+		Seen:        q.seenList(),
+		Records:     q.records,
+		NextOffset:  q.nextOffset,
+		Groups:      q.groups,
+		Sequence:    q.sequence,
+	})
 }
 
-func (s *Subscriber) Unsubscribe(addr string) error {
-	delete(s.Member, addr)
-	for i, l := range s.List {
-		if l == addr {
-			if i+1 == len(s.List) {
-				s.List = s.List[:i]
-			} else {
-				s.List = append(s.List[:i], s.List[i+1:]...)
-			}
-			return nil
-		}
+// Clone returns a deep copy of the queue.
+func (q *Queue) Clone() *Queue {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	c := NewQueue()
+	c.mode = q.mode
+	c.consistency = q.consistency
+	for k := range q.KeySet {
+		c.KeySet[k] = struct{}{}
 	}
-	return nil
+	q.walk(c.pushBack)
+	for _, d := range q.scheduledList() {
+		c.schedule(d)
+	}
+	for r, l := range q.InFlight {
+		c.InFlight[r] = l
+		c.leased[l.Data.Key] = r
+		c.trackLease(l)
+	}
+	for _, d := range q.DeadLetter {
+		c.DeadLetter = append(c.DeadLetter, d)
+		c.track(d)
+	}
+	for _, s := range q.seenList() {
+		c.seen[s.ID] = c.seenOrder.PushBack(s)
+	}
+	c.records = append(c.records, q.records...)
+	c.nextOffset = q.nextOffset
+	for name, offset := range q.groups {
+		c.groups[name] = offset
+	}
+	c.sequence = q.sequence
+	return c
 }
 
-func (s *Subscriber) Send(data Data) error {
-	index := rand.Intn(len(s.Member))
-	conn := s.Member[s.List[index]]
-	body, err := json.Marshal(data)
-	if err != nil {
-		return err
-	}
+// Reset drops every item of the queue.
+func (q *Queue) Reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
 
-	err = conn.WriteMessage(websocket.TextMessage, []byte(body))
-	if err != nil {
-		_ = s.Unsubscribe(s.List[index])
-		if len(s.List) > 0 {
-			return s.Send(data)
-		}
-		return err
-	}
-	return nil
+	q.KeySet = make(map[string]struct{})
+	q.levels = make(map[int]*list.List)
+	q.priorities = nil
+	q.elements = make(map[string]*list.Element)
+	q.delayed = nil
+	q.delayedKeys = make(map[string]*scheduled)
+	q.seen = make(map[string]*list.Element)
+	q.seenOrder = list.New()
+	q.records = nil
+	q.nextOffset = 0
+	q.groups = make(map[string]uint64)
+	q.InFlight = make(map[string]Lease)
+	q.leased = make(map[string]string)
+	q.DeadLetter = make([]Data, 0)
+	q.expiries = nil
+	q.expiring = make(map[string]time.Time)
+	q.leases = nil
 }
 
-func NewSubscriber() *Subscriber {
-	return &Subscriber{
-		Member: make(map[string]*websocket.Conn),
+// list returns queued items in order. Callers should hold q.mu.
+func (q *Queue) list() []Data {
+	l := make([]Data, 0, len(q.elements))
+	q.walk(func(d Data) {
+		l = append(l, d)
+	})
+	return l
+}
+
+func NewQueue() *Queue {
+	return &Queue{
+		KeySet:      make(map[string]struct{}),
+		levels:      make(map[int]*list.List),
+		elements:    make(map[string]*list.Element),
+		delayedKeys: make(map[string]*scheduled),
+		seen:        make(map[string]*list.Element),
+		seenOrder:   list.New(),
+		groups:      make(map[string]uint64),
+		InFlight:    make(map[string]Lease),
+		leased:      make(map[string]string),
+		DeadLetter:  make([]Data, 0),
+		expiring:    make(map[string]time.Time),
 	}
 }
@@ -0,0 +1,722 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// checkKeys fails unless the key set holds exactly the keys of the items.
+func checkKeys(t *testing.T, q *Queue) {
+	c := q.Clone()
+
+	keys := make(map[string]struct{})
+	for _, d := range append(c.list(), c.scheduledList()...) {
+		keys[d.Key] = struct{}{}
+	}
+	for _, l := range c.InFlight {
+		keys[l.Data.Key] = struct{}{}
+	}
+	for _, d := range c.DeadLetter {
+		keys[d.Key] = struct{}{}
+	}
+
+	if len(keys) != len(c.KeySet) {
+		t.Errorf("key set has %d keys, items have %d", len(c.KeySet), len(keys))
+		return
+	}
+	for k := range keys {
+		if _, ok := c.KeySet[k]; !ok {
+			t.Errorf("key %q is missing from key set", k)
+			return
+		}
+	}
+}
+
+func TestConcurrentQueue(t *testing.T) {
+	q := NewQueue()
+	s := NewSubscriber()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprintf("%d-%d", w, i)
+				_ = q.Push(Data{Key: key, Value: key})
+
+				switch i % 5 {
+				case 0:
+					_, _ = q.Pull(time.Now())
+				case 1:
+					_ = q.Delete(key)
+				case 2:
+					l, err := q.Lease(key, time.Now(), time.Now())
+					if err == nil && i%2 == 0 {
+						_, _ = q.Ack(l.Receipt)
+					}
+				case 3:
+					q.Expire(time.Now(), 1)
+				case 4:
+					_, _ = q.Redrive("")
+				}
+
+				addr := fmt.Sprintf("%d", w)
+				_, _ = s.Subscribe(nil, addr, Subscription{})
+				_ = s.Len()
+				_ = s.Unsubscribe(addr)
+			}
+		}(w)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			checkKeys(t, q)
+			if s.Len() != 0 {
+				t.Errorf("%d subscribers left", s.Len())
+			}
+			return
+		default:
+			checkKeys(t, q)
+		}
+	}
+}
+
+func TestQueueJSON(t *testing.T) {
+	q := NewQueue()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		_ = q.Push(Data{Key: k, Value: k})
+	}
+	l, _ := q.Lease("r", time.Now(), time.Now().Add(time.Hour))
+	_ = q.Delete("c")
+
+	b, err := json.Marshal(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewQueue()
+	if err := r.BulkPush(b); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, r)
+
+	if got := fmt.Sprint(r.list()); got != fmt.Sprint([]Data{{Key: "b", Value: "b"}, {Key: "d", Value: "d"}}) {
+		t.Fatalf("unexpected items %s", got)
+	}
+	if _, err := r.Release(l.Receipt, 0); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := r.Pull(time.Now()); d.Key != "a" {
+		t.Fatalf("released item should be at head, got %q", d.Key)
+	}
+}
+
+// fill returns a queue holding n items keyed by their index.
+func fill(n int) *Queue {
+	q := NewQueue()
+	for i := 0; i < n; i++ {
+		_ = q.Push(Data{Key: strconv.Itoa(i)})
+	}
+	return q
+}
+
+func BenchmarkPushPull(b *testing.B) {
+	q := fill(1000000)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = q.Push(Data{Key: "n" + strconv.Itoa(i)})
+		if _, err := q.Pull(time.Now()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDelete(b *testing.B) {
+	const n = 1000000
+	q := fill(n)
+	keys := rand.Perm(n)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		key := strconv.Itoa(keys[i%n])
+		if err := q.Delete(key); err != nil {
+			b.Fatal(err)
+		}
+		_ = q.Push(Data{Key: key})
+	}
+}
+
+func BenchmarkLeaseAck(b *testing.B) {
+	q := fill(1000000)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		l, err := q.Lease(strconv.Itoa(i), time.Time{}, time.Time{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := q.Ack(l.Receipt); err != nil {
+			b.Fatal(err)
+		}
+		_ = q.Push(l.Data)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	q := NewQueue()
+	results := q.PushBatch([]Data{{Key: "a"}, {Key: "a"}, {Key: "b"}}, time.Now(), 0)
+	if results[0].Error != "" || results[1].Error != ErrKeyExist.Error() || results[2].Error != "" {
+		t.Fatalf("unexpected results %v", results)
+	}
+
+	if got := q.LeaseBatch([]string{"r"}, time.Now(), time.Now()); len(got) != 1 || got[0].Data.Key != "a" {
+		t.Fatalf("unexpected leases %v", got)
+	}
+	if got := q.PullBatch(5, time.Now()); len(got) != 1 || got[0].Key != "b" {
+		t.Fatalf("unexpected items %v", got)
+	}
+	checkKeys(t, q)
+}
+
+func TestLease(t *testing.T) {
+	now := time.Now()
+	deadline := now.Add(time.Minute)
+
+	// Replicas apply the same commands and end up with the same queue.
+	replicas := []*Queue{NewQueue(), NewQueue()}
+	for _, q := range replicas {
+		_ = q.Push(Data{Key: "a"})
+		_ = q.Push(Data{Key: "b"})
+
+		l, err := q.Lease("r1", now, deadline)
+		if err != nil || l.Data.Key != "a" || l.Data.Deliveries != 1 {
+			t.Fatalf("unexpected lease %v %v", l, err)
+		}
+		if d, _ := q.Peek(now); d.Key != "b" {
+			t.Fatalf("leased item is still visible, head is %q", d.Key)
+		}
+
+		// Past its visibility timeout the item reappears at the head.
+		if q.Expired(now) || !q.Expired(deadline) {
+			t.Fatal("lease expired at the wrong time")
+		}
+		if expired := q.Expire(deadline, 0); len(expired) != 1 {
+			t.Fatalf("expected one expired lease, got %v", expired)
+		}
+		if d, _ := q.Peek(now); d.Key != "a" {
+			t.Fatalf("expired item is not at the head, got %q", d.Key)
+		}
+
+		if _, err := q.Lease("r2", now, deadline); err != nil {
+			t.Fatal(err)
+		}
+		if d, err := q.Ack("r2"); err != nil || d.Key != "a" || d.Deliveries != 2 {
+			t.Fatalf("unexpected ack %v %v", d, err)
+		}
+		if _, err := q.Ack("r2"); err != ErrReceiptNotFound {
+			t.Fatalf("expected receipt not found, got %v", err)
+		}
+		checkKeys(t, q)
+	}
+
+	a, _ := json.Marshal(replicas[0])
+	b, _ := json.Marshal(replicas[1])
+	if string(a) != string(b) {
+		t.Fatalf("replicas differ:\n%s\n%s", a, b)
+	}
+	if _, ok := replicas[0].KeySet["a"]; ok {
+		t.Fatal("acked item is still known")
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	now := time.Now()
+	q := NewQueue()
+	_ = q.Push(Data{Key: "a"})
+
+	for i := 1; i <= 3; i++ {
+		receipt := strconv.Itoa(i)
+		if _, err := q.Lease(receipt, now, now); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+		if _, err := q.Release(receipt, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := q.Pull(now); err != ErrEmptyList {
+		t.Fatalf("expected item in the dead-letter queue, got %v", err)
+	}
+	if len(q.DeadLetter) != 1 || q.DeadLetter[0].Deliveries != 3 {
+		t.Fatalf("unexpected dead-letter queue %v", q.DeadLetter)
+	}
+	checkKeys(t, q)
+
+	// An item given back unseen does not count as delivered.
+	moved, _ := q.Redrive("a")
+	l, _ := q.Lease("4", now, now)
+	if d, err := q.Return(l.Receipt); err != nil || d.Deliveries != 0 || len(q.DeadLetter) != 0 {
+		t.Fatalf("unexpected return %v %v", d, err)
+	}
+	_, _ = q.Lease("5", now, now)
+	_, _ = q.Release("5", 1)
+	if len(moved) != 1 || len(q.DeadLetter) != 1 {
+		t.Fatalf("unexpected dead-letter queue %v", q.DeadLetter)
+	}
+
+	if _, err := q.Redrive("b"); err != ErrKeyNotFound {
+		t.Fatalf("expected key not found, got %v", err)
+	}
+	moved, err := q.Redrive("a")
+	if err != nil || len(moved) != 1 || len(q.DeadLetter) != 0 {
+		t.Fatalf("unexpected redrive %v %v", moved, err)
+	}
+	if d, err := q.Pull(now); err != nil || d.Key != "a" || d.Deliveries != 0 {
+		t.Fatalf("redriven item came back as %v %v", d, err)
+	}
+	checkKeys(t, q)
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Second)
+
+	q := NewQueue()
+	_ = q.Push(Data{Key: "a", ExpiresAt: &past})
+	_ = q.Push(Data{Key: "b"})
+	_ = q.Push(Data{Key: "c", ExpiresAt: &past})
+
+	if d, err := q.Pull(now); err != nil || d.Key != "b" {
+		t.Fatalf("expected b, got %q %v", d.Key, err)
+	}
+	if !q.Stale(now) {
+		t.Fatal("queue should have a stale item")
+	}
+	if reaped := q.Reap(now); len(reaped) != 1 || reaped[0].Key != "c" {
+		t.Fatalf("unexpected reaped items %v", reaped)
+	}
+	if _, err := q.Pull(now); err != ErrEmptyList {
+		t.Fatalf("expected empty queue, got %v", err)
+	}
+	checkKeys(t, q)
+}
+
+func TestDeadlines(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	q := NewQueue()
+	_ = q.Push(Data{Key: "queued", ExpiresAt: at(3 * time.Second)})
+	_ = q.Push(Data{Key: "scheduled", ExpiresAt: at(2 * time.Second), DeliverAt: at(time.Hour)})
+	_ = q.Push(Data{Key: "dead", ExpiresAt: at(time.Second)})
+	_ = q.Push(Data{Key: "pulled", ExpiresAt: at(-time.Second)})
+	_ = q.Push(Data{Key: "kept"})
+
+	// Items gone from the queue no longer count as stale.
+	if err := q.Delete("pulled"); err != nil {
+		t.Fatal(err)
+	}
+	if q.Stale(now) {
+		t.Fatal("queue should have no stale item")
+	}
+
+	// An in-flight item is not reaped, the dead-letter one is.
+	dead, _ := q.Lease("r1", now, now.Add(time.Second), Filter{KeyPrefix: "dead"})
+	_, _ = q.Release(dead.Receipt, 1)
+	_, _ = q.Lease("r2", now, now.Add(2*time.Second), Filter{KeyPrefix: "queued"})
+	if q.Stale(now.Add(500*time.Millisecond)) || !q.Stale(now.Add(time.Second)) {
+		t.Fatal("dead-letter item should expire after a second")
+	}
+	if reaped := q.Reap(now.Add(5 * time.Second)); len(reaped) != 2 || reaped[0].Key != "scheduled" || reaped[1].Key != "dead" {
+		t.Fatalf("unexpected reaped items %v", reaped)
+	}
+
+	// The expired lease comes back with its expiry and is reaped then.
+	if q.Expired(now.Add(time.Second)) || !q.Expired(now.Add(2*time.Second)) {
+		t.Fatal("lease should end after two seconds")
+	}
+	if expired := q.Expire(now.Add(2*time.Second), 0); len(expired) != 1 || expired[0].Receipt != "r2" {
+		t.Fatalf("unexpected expired leases %v", expired)
+	}
+	if q.Expired(now.Add(time.Hour)) {
+		t.Fatal("no lease should be left")
+	}
+	if reaped := q.Reap(now.Add(5 * time.Second)); len(reaped) != 1 || reaped[0].Key != "queued" {
+		t.Fatalf("unexpected reaped items %v", reaped)
+	}
+	if q.Stale(now.Add(time.Hour)) {
+		t.Fatal("queue should have no stale item")
+	}
+	checkKeys(t, q)
+
+	// A copy tracks the same deadlines.
+	_ = q.Push(Data{Key: "copied", ExpiresAt: at(time.Second)})
+	if c := q.Clone(); !c.Stale(now.Add(time.Second)) {
+		t.Fatal("copy should have a stale item")
+	}
+}
+
+func TestPriority(t *testing.T) {
+	q := NewQueue()
+	for i, p := range []int{0, 5, 0, 5, -1, 9} {
+		_ = q.Push(Data{Key: strconv.Itoa(i), Priority: p})
+	}
+	l, _ := q.Lease("r", time.Now(), time.Now())
+	if l.Data.Key != "5" {
+		t.Fatalf("expected highest priority first, got %q", l.Data.Key)
+	}
+	_, _ = q.Release("r", 0)
+
+	b, _ := json.Marshal(q)
+	r := NewQueue()
+	if err := r.BulkPush(b); err != nil {
+		t.Fatal(err)
+	}
+
+	order := make([]string, 0)
+	for d, err := r.Pull(time.Now()); err == nil; d, err = r.Pull(time.Now()) {
+		order = append(order, d.Key)
+	}
+	if fmt.Sprint(order) != "[5 1 3 0 2 4]" {
+		t.Fatalf("unexpected order %v", order)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	q := NewQueue()
+	_ = q.Push(Data{Key: "late", DeliverAt: at(2 * time.Second)})
+	_ = q.Push(Data{Key: "soon", DeliverAt: at(time.Second)})
+	_ = q.Push(Data{Key: "now"})
+
+	if d, _ := q.Pull(now); d.Key != "now" {
+		t.Fatalf("expected now, got %q", d.Key)
+	}
+	if _, err := q.Pull(now); err != ErrEmptyList {
+		t.Fatalf("scheduled items should not be pulled early, got %v", err)
+	}
+
+	b, _ := json.Marshal(q)
+	r := NewQueue()
+	if err := r.BulkPush(b); err != nil {
+		t.Fatal(err)
+	}
+	if r.Delayed() != 2 || r.Ready(now) || !r.Ready(now.Add(time.Second)) {
+		t.Fatal("restored queue lost the schedule")
+	}
+
+	got := r.PullBatch(5, now.Add(3*time.Second))
+	if len(got) != 2 || got[0].Key != "soon" || got[1].Key != "late" {
+		t.Fatalf("unexpected items %v", got)
+	}
+	checkKeys(t, r)
+}
+
+func TestDedup(t *testing.T) {
+	now := time.Now()
+	q := NewQueue()
+
+	first, err := q.PushOnce(Data{Key: "a", Value: "1"}, now, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = q.Pull(now)
+
+	retry, err := q.PushOnce(Data{Key: "a", Value: "2"}, now.Add(time.Second), time.Minute)
+	if err != nil || retry.Value != first.Value || q.Len() != 0 {
+		t.Fatalf("retry was enqueued again: %v %v", retry, err)
+	}
+	results := q.PushBatch([]Data{{Key: "b", IdempotencyKey: "a"}}, now.Add(time.Second), time.Minute)
+	if !results[0].Duplicate {
+		t.Fatalf("expected a duplicate, got %v", results)
+	}
+
+	if _, err := q.PushOnce(Data{Key: "a", Value: "3"}, now.Add(2*time.Minute), time.Minute); err != nil || q.Len() != 1 {
+		t.Fatalf("item should be pushed once the window passed: %v", err)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	q := NewQueue()
+	_ = q.Push(Data{Key: "a"})
+	if _, err := q.CreateGroup("early", OffsetEarliest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.CreateGroup("late", ""); err != nil {
+		t.Fatal(err)
+	}
+	_ = q.Push(Data{Key: "b"})
+	_ = q.Push(Data{Key: "c"})
+	q.Trim(2)
+
+	early, _ := q.Consume("early", 5)
+	late, _ := q.Consume("late", 1)
+	if len(early) != 2 || early[0].Data.Key != "b" || len(late) != 1 || late[0].Data.Key != "b" {
+		t.Fatalf("unexpected records %v %v", early, late)
+	}
+
+	if _, err := q.ResetGroup("early", "", 0); err != ErrInvalidOffset {
+		t.Fatalf("trimmed offset should be rejected, got %v", err)
+	}
+	if g, _ := q.Commit("late", 2); g.Lag != 0 {
+		t.Fatalf("unexpected lag %d", g.Lag)
+	}
+
+	b, _ := json.Marshal(q)
+	r := NewQueue()
+	if err := r.BulkPush(b); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(r.Groups()) != fmt.Sprint(q.Groups()) {
+		t.Fatalf("groups were not restored: %v", r.Groups())
+	}
+
+	// Without groups the log keeps nothing.
+	_ = q.DeleteGroup("early")
+	_ = q.DeleteGroup("late")
+	_ = q.Push(Data{Key: "d"})
+	if len(q.records) != 0 {
+		t.Fatalf("queue without groups kept records %v", q.records)
+	}
+	if g, _ := q.CreateGroup("new", OffsetEarliest); g.Offset != 4 || g.Lag != 0 {
+		t.Fatalf("unexpected group %v", g)
+	}
+}
+
+func TestSubscriber(t *testing.T) {
+	s := NewSubscriber()
+	settled := make(chan error, 1)
+	s.SetDone(func(d Delivery, err error) {
+		settled <- err
+	})
+
+	js := &Conn{out: make(chan message, connBuffer)}
+	plain := &Conn{out: make(chan message, connBuffer)}
+	_, _ = s.Subscribe(js, "json", Subscription{Filter: Filter{ContentType: "application/json"}, Manual: true})
+	_, _ = s.Subscribe(plain, "text", Subscription{Filter: Filter{ContentType: "text/plain"}})
+
+	if f := s.Filters(); len(f) != 2 {
+		t.Fatalf("unexpected filters %v", f)
+	}
+	if err := s.Send(Delivery{Receipt: "r", Data: Data{ContentType: "application/json"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(js.out) != 1 || len(plain.out) != 0 {
+		t.Fatal("item went to a subscriber not picking it")
+	}
+	if err := s.Send(Delivery{Receipt: "x", Data: Data{ContentType: "text/csv"}}); err != ErrNoSubscriber {
+		t.Fatalf("expected no subscriber, got %v", err)
+	}
+
+	msg := <-js.out
+	s.written(msg.member, *msg.delivery, nil)
+	select {
+	case err := <-settled:
+		t.Fatalf("manual item settled before ack: %v", err)
+	default:
+	}
+	if err := s.Settle("json", "r", ErrRejected); err != nil || <-settled != ErrRejected {
+		t.Fatalf("nack was not reported: %v", err)
+	}
+	if err := s.Settle("json", "r", nil); err != ErrUnknownMessage {
+		t.Fatalf("expected unknown message, got %v", err)
+	}
+
+	// Closing reports the items still waiting to be written.
+	if err := s.Send(Delivery{Receipt: "p", Data: Data{ContentType: "text/plain"}}); err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("close did not return")
+	}
+	if err := <-settled; err != ErrConnClosed {
+		t.Fatalf("expected the unwritten item reported closed, got %v", err)
+	}
+	if s.Len() != 0 {
+		t.Fatalf("%d subscribers left", s.Len())
+	}
+
+	// Subscribers without a connection are left out in every mode.
+	for _, mode := range []string{ModeRandom, ModeBroadcast} {
+		s := NewSubscriber()
+		s.SetMode(mode)
+		c := &Conn{out: make(chan message, connBuffer)}
+		_, _ = s.Subscribe(nil, "none", Subscription{})
+		_, _ = s.Subscribe(c, "conn", Subscription{})
+		for i := 0; i < 4; i++ {
+			if err := s.Send(Delivery{Receipt: strconv.Itoa(i)}); err != nil {
+				t.Fatalf("%s: %v", mode, err)
+			}
+		}
+		if len(c.out) != 4 || s.Len() != 2 {
+			t.Fatalf("%s: expected 4 items written and 2 subscribers, got %d and %d", mode, len(c.out), s.Len())
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	q := NewQueue()
+	_ = q.Push(Data{Key: "email/1", Headers: map[string]string{"lang": "en"}})
+	_ = q.Push(Data{Key: "sms/1"})
+	_ = q.Push(Data{Key: "email/2", Headers: map[string]string{"lang": "fa"}})
+
+	if d, err := q.Pull(time.Now(), Filter{KeyPrefix: "sms/"}); err != nil || d.Key != "sms/1" {
+		t.Fatalf("expected sms/1, got %q %v", d.Key, err)
+	}
+	l, err := q.Lease("r", time.Now(), time.Now(), Filter{KeyPattern: "email/*", Headers: map[string]string{"lang": "fa"}})
+	if err != nil || l.Data.Key != "email/2" {
+		t.Fatalf("expected email/2, got %q %v", l.Data.Key, err)
+	}
+	if got := q.PullBatch(5, time.Now(), Filter{KeyPrefix: "sms/"}); len(got) != 0 {
+		t.Fatalf("unexpected items %v", got)
+	}
+	if d, _ := q.Pull(time.Now()); d.Key != "email/1" {
+		t.Fatalf("skipped item lost its place, got %q", d.Key)
+	}
+	if err := (Filter{KeyPattern: "["}).Validate(); err != ErrInvalidFilter {
+		t.Fatalf("expected invalid filter, got %v", err)
+	}
+	checkKeys(t, q)
+}
+
+func TestRevoke(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Minute)
+	q := NewQueue()
+
+	_ = q.Push(Data{Key: "c", EnqueuedAt: now})
+	c, _ := q.Pull(now)
+	a, _ := q.PushOnce(Data{Key: "a", EnqueuedAt: now}, now, time.Minute)
+	b, _ := q.PushOnce(Data{Key: "b", EnqueuedAt: now, DeliverAt: &later}, now, time.Minute)
+
+	// A stale item with the key of a queued one is left alone, like one
+	// already pulled.
+	stale := Data{Key: "a", EnqueuedAt: now.Add(-time.Second)}
+	if got := q.Revoke([]Data{stale, c}); len(got) != 0 {
+		t.Fatalf("expected nothing revoked, got %v", got)
+	}
+	if got := q.Revoke([]Data{a, b}); len(got) != 2 {
+		t.Fatalf("expected both items revoked, got %v", got)
+	}
+	if q.Len() != 0 || q.Delayed() != 0 {
+		t.Fatal("revoked items are still queued")
+	}
+	checkKeys(t, q)
+
+	// A retry of a revoked push is not taken for a duplicate.
+	if _, err := q.PushOnce(Data{Key: "a", EnqueuedAt: now}, now, time.Minute); err != nil || q.Len() != 1 {
+		t.Fatalf("retry was not pushed: %v", err)
+	}
+
+	// Groups do not read revoked items either.
+	_, _ = q.CreateGroup("g", OffsetLatest)
+	d, _ := q.PushOnce(Data{Key: "d", EnqueuedAt: now}, now, time.Minute)
+	e, _ := q.PushOnce(Data{Key: "e", EnqueuedAt: now}, now, time.Minute)
+	if got := q.Revoke([]Data{d}); len(got) != 1 {
+		t.Fatalf("expected d revoked, got %v", got)
+	}
+	if g := q.Groups(); g[0].Lag != 1 {
+		t.Fatalf("unexpected lag %d", g[0].Lag)
+	}
+	records, _ := q.Consume("g", 5)
+	if len(records) != 1 || records[0].Data.Key != e.Key {
+		t.Fatalf("unexpected records %v", records)
+	}
+	if g := q.Groups(); g[0].Lag != 0 {
+		t.Fatalf("unexpected lag %d", g[0].Lag)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	now := time.Now()
+	a, b := NewQueue(), NewQueue()
+	for _, q := range []*Queue{a, b} {
+		for _, k := range []string{"a", "b", "c"} {
+			_ = q.Push(Data{Key: k, Value: k, EnqueuedAt: now})
+		}
+	}
+
+	da, err := a.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, _ := b.Digest()
+	if da.Root != db.Root || len(da.Diff(db)) != 0 || da.Items != 3 {
+		t.Fatalf("equal queues differ: %v %v", da, db)
+	}
+
+	// A stale item only differs in its bucket.
+	_ = b.Delete("b")
+	_ = b.Push(Data{Key: "b", Value: "stale", EnqueuedAt: now})
+	db, _ = b.Digest()
+	if da.Root == db.Root {
+		t.Fatal("stale item was not noticed")
+	}
+	diff := da.Diff(db)
+	if len(diff) != 1 || diff[0] != Bucket("b") {
+		t.Fatalf("expected bucket of b to differ, got %v", diff)
+	}
+
+	// Only the items of that bucket are looked at, b among them.
+	ia, _ := a.BucketItems(diff)
+	ib, _ := b.BucketItems(diff)
+	if ia["b"] == "" || ib["b"] == "" || ia["b"] == ib["b"] || len(ia) != len(ib) {
+		t.Fatalf("unexpected items %v %v", ia, ib)
+	}
+	for k := range ia {
+		if Bucket(k) != diff[0] {
+			t.Fatalf("item %q is not in bucket %d", k, diff[0])
+		}
+	}
+}
+
+func TestSequence(t *testing.T) {
+	now := time.Now()
+	q := NewQueue()
+
+	_, _ = q.PushOnce(Data{Key: "a", Sequence: 1}, now, time.Minute)
+	results := q.PushBatch([]Data{{Key: "b", Sequence: 2}, {Key: "a", Sequence: 3}, {Key: "c", Sequence: 4}}, now, time.Minute)
+	if results[0].Sequence != 2 || !results[1].Duplicate || results[1].Sequence != 1 || results[2].Sequence != 4 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if q.Sequence() != 4 {
+		t.Fatalf("expected sequence 4, got %d", q.Sequence())
+	}
+
+	// Pulling items does not take the sequence of the queue back.
+	_ = q.PullBatch(3, now)
+	b, _ := json.Marshal(q)
+	c := NewQueue()
+	if err := c.BulkPush(b); err != nil {
+		t.Fatal(err)
+	}
+	if c.Sequence() != 4 || q.Clone().Sequence() != 4 {
+		t.Fatalf("sequence was lost, got %d", c.Sequence())
+	}
+}
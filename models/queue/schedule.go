@@ -0,0 +1,114 @@
+package models
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+// scheduled is an item waiting for its delivery time.
+type scheduled struct {
+	data Data
+	// seq keeps items due at the same time in the order they came.
+	seq   uint64
+	index int
+}
+
+// schedule is a heap of scheduled items, the earliest due on top.
+type schedule []*scheduled
+
+func (s schedule) Len() int { return len(s) }
+
+func (s schedule) Less(i, j int) bool {
+	if s[i].data.DeliverAt.Equal(*s[j].data.DeliverAt) {
+		return s[i].seq < s[j].seq
+	}
+	return s[i].data.DeliverAt.Before(*s[j].data.DeliverAt)
+}
+
+func (s schedule) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+	s[i].index = i
+	s[j].index = j
+}
+
+func (s *schedule) Push(x interface{}) {
+	item := x.(*scheduled)
+	item.index = len(*s)
+	*s = append(*s, item)
+}
+
+func (s *schedule) Pop() interface{} {
+	old := *s
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*s = old[:len(old)-1]
+	return item
+}
+
+// Ready reports whether an item can be pulled at now, either queued or
+// scheduled and due.
+func (q *Queue) Ready(now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.elements) > 0 {
+		return true
+	}
+	return len(q.delayed) > 0 && !q.delayed[0].data.DeliverAt.After(now)
+}
+
+// Delayed returns the number of items waiting for their delivery time.
+func (q *Queue) Delayed() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.delayed)
+}
+
+// schedule holds d back until its delivery time. Callers should hold q.mu.
+func (q *Queue) schedule(d Data) {
+	s := &scheduled{data: d, seq: q.seq}
+	q.seq++
+	heap.Push(&q.delayed, s)
+	q.delayedKeys[d.Key] = s
+	q.track(d)
+}
+
+// unschedule drops the scheduled item with key. Callers should hold q.mu.
+func (q *Queue) unschedule(key string) bool {
+	s, ok := q.delayedKeys[key]
+	if !ok {
+		return false
+	}
+	heap.Remove(&q.delayed, s.index)
+	delete(q.delayedKeys, key)
+	q.untrack(key)
+	return true
+}
+
+// promote queues scheduled items due at now in the order they are due.
+// A zero now promotes nothing. Callers should hold q.mu.
+func (q *Queue) promote(now time.Time) {
+	if now.IsZero() {
+		return
+	}
+	for len(q.delayed) > 0 && !q.delayed[0].data.DeliverAt.After(now) {
+		s := heap.Pop(&q.delayed).(*scheduled)
+		delete(q.delayedKeys, s.data.Key)
+		q.pushBack(s.data)
+	}
+}
+
+// scheduledList returns scheduled items in the order they are due.
+// Callers should hold q.mu.
+func (q *Queue) scheduledList() []Data {
+	s := append(schedule(nil), q.delayed...)
+	sort.Slice(s, func(i, j int) bool { return s.Less(i, j) })
+
+	l := make([]Data, len(s))
+	for i := range s {
+		l[i] = s[i].data
+	}
+	return l
+}
@@ -0,0 +1,402 @@
+package models
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Delivery modes tell how items reach the subscribers of a queue. Random
+// and round-robin give each item to a single subscriber, broadcast gives
+// it to all of them.
+const (
+	ModeRandom     = "random"
+	ModeRoundRobin = "round-robin"
+	ModeBroadcast  = "broadcast"
+)
+
+// memberWindow is how many items a subscriber is handed by random or
+// round-robin before it settled the earlier ones.
+const memberWindow = 16
+
+// ValidMode reports whether mode is a delivery mode, empty meaning random.
+func ValidMode(mode string) bool {
+	switch mode {
+	case "", ModeRandom, ModeRoundRobin, ModeBroadcast:
+		return true
+	}
+	return false
+}
+
+// Delivery is an item on its way to a subscriber of Queue, or of its
+// consumer group Group, with the receipt and deadline of its lease or its
+// offset in the log for consumer groups.
+type Delivery struct {
+	Queue    string
+	Group    string
+	Data     Data
+	Receipt  string
+	Deadline time.Time
+	Offset   uint64
+}
+
+// ID returns what the client acks the delivery with.
+func (d Delivery) ID() string {
+	if d.Group != "" {
+		return strconv.FormatUint(d.Offset, 10)
+	}
+	return d.Receipt
+}
+
+// DoneFunc learns whether a delivery was settled by its subscriber, that
+// is written to it or acked by it. It should not block long.
+type DoneFunc func(d Delivery, err error)
+
+// Subscription is how a client wants to be handed items.
+type Subscription struct {
+	Filter Filter
+	// Manual waits for the client to ack or nack each item instead of
+	// acking it once written.
+	Manual bool
+	// Lease leaves items written to the client leased, for it to ack them
+	// like any other lease.
+	Lease bool
+	// Credit is how many items the client takes before it asks for more,
+	// zero for no limit.
+	Credit int
+}
+
+type Subscriber struct {
+	// mu guards the members, mode and next.
+	mu     sync.Mutex
+	Member map[string]*Member
+	List   []string
+	mode   string
+	// next is the member round-robin delivers to next.
+	next int
+	done DoneFunc
+}
+
+// Member is a subscription of a connection.
+type Member struct {
+	sub  *Subscriber
+	addr string
+	conn *Conn
+	Subscription
+	// credit is how many more items the member asked for, negative if it
+	// never asked and takes whatever comes.
+	credit int
+	// pending counts items handed to the member and not settled yet,
+	// unacked the ones of them written and waiting for the client.
+	pending int
+	unacked map[string]Delivery
+}
+
+// ready reports whether the member can be handed another item. Callers
+// should hold s.mu.
+func (m *Member) ready() bool {
+	return m.credit != 0 && m.pending < memberWindow && (m.conn == nil || !m.conn.full())
+}
+
+// written learns from the writer of m whether d was written. Items of
+// manual members wait for the client unless it is gone.
+func (s *Subscriber) written(m *Member, d Delivery, err error) {
+	s.mu.Lock()
+	current := s.Member[m.addr] == m
+	if err == nil && m.Lease {
+		m.pending--
+		s.mu.Unlock()
+		return
+	}
+	if err == nil && m.Manual {
+		if current {
+			m.unacked[d.ID()] = d
+			s.mu.Unlock()
+			return
+		}
+		err = ErrNotSubscribed
+	}
+	m.pending--
+	done := s.done
+	s.mu.Unlock()
+
+	if done != nil {
+		done(d, err)
+	}
+}
+
+// SetDone sets the handler learning about deliveries of random and
+// round-robin modes.
+func (s *Subscriber) SetDone(done DoneFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.done = done
+}
+
+func (s *Subscriber) Subscribe(c *Conn, addr string, sub Subscription) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.Member[addr]; ok {
+		return "", ErrSubscriberExist
+	}
+	if err := sub.Filter.Validate(); err != nil {
+		return "", err
+	}
+	m := &Member{
+		sub:          s,
+		addr:         addr,
+		conn:         c,
+		Subscription: sub,
+		credit:       -1,
+		unacked:      make(map[string]Delivery),
+	}
+	if sub.Credit > 0 {
+		m.credit = sub.Credit
+	}
+	s.Member[addr] = m
+	s.List = append(s.List, addr)
+	return "You subscribe successfully", nil
+}
+
+// Unsubscribe removes the subscriber at addr. Items it did not ack are
+// given back.
+func (s *Subscriber) Unsubscribe(addr string) error {
+	s.mu.Lock()
+	unacked, err := s.unsubscribe(addr)
+	done := s.done
+	s.mu.Unlock()
+
+	if done != nil {
+		for _, d := range unacked {
+			done(d, ErrNotSubscribed)
+		}
+	}
+	return err
+}
+
+// unsubscribe removes the member at addr and returns the items it did not
+// ack. Items not written yet are reported by the writer. Callers should
+// hold s.mu.
+func (s *Subscriber) unsubscribe(addr string) ([]Delivery, error) {
+	m, ok := s.Member[addr]
+	if !ok {
+		return nil, ErrNotSubscribed
+	}
+	delete(s.Member, addr)
+	for i, l := range s.List {
+		if l == addr {
+			s.List = append(s.List[:i], s.List[i+1:]...)
+			break
+		}
+	}
+
+	unacked := make([]Delivery, 0, len(m.unacked))
+	for _, d := range m.unacked {
+		unacked = append(unacked, d)
+	}
+	return unacked, nil
+}
+
+// Settle acks or, with a non-nil err, nacks the item id written to the
+// subscriber at addr.
+func (s *Subscriber) Settle(addr string, id string, err error) error {
+	s.mu.Lock()
+	m, ok := s.Member[addr]
+	if !ok {
+		s.mu.Unlock()
+		return ErrNotSubscribed
+	}
+	d, ok := m.unacked[id]
+	if !ok {
+		s.mu.Unlock()
+		return ErrUnknownMessage
+	}
+	delete(m.unacked, id)
+	m.pending--
+	done := s.done
+	s.mu.Unlock()
+
+	if done != nil {
+		done(d, err)
+	}
+	return nil
+}
+
+// Credit lets the subscriber at addr be handed n more items. A subscriber
+// that never gave credit is handed items without limit.
+func (s *Subscriber) Credit(addr string, n int) error {
+	if n <= 0 {
+		return ErrInvalidCredit
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.Member[addr]
+	if !ok {
+		return ErrNotSubscribed
+	}
+	if m.credit < 0 {
+		m.credit = 0
+	}
+	m.credit += n
+	return nil
+}
+
+// SetMode changes how items are delivered, empty meaning random.
+func (s *Subscriber) SetMode(mode string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mode = mode
+}
+
+// Len returns the number of subscribers.
+func (s *Subscriber) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.List)
+}
+
+// Ready reports whether a subscriber can be handed an item now.
+func (s *Subscriber) Ready() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mode == ModeBroadcast {
+		return len(s.List) > 0
+	}
+	for _, m := range s.Member {
+		if m.ready() {
+			return true
+		}
+	}
+	return false
+}
+
+// Filters returns the filters of subscribers that can be handed an item
+// now, nil if one of them takes any item.
+func (s *Subscriber) Filters() []Filter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	filters := make([]Filter, 0, len(s.Member))
+	for _, m := range s.Member {
+		if s.mode != ModeBroadcast && !m.ready() {
+			continue
+		}
+		if m.Filter.Empty() {
+			return nil
+		}
+		filters = append(filters, m.Filter)
+	}
+	return filters
+}
+
+// Wants reports whether any subscriber, ready or not, would take d.
+func (s *Subscriber) Wants(d Data) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.Member {
+		if m.Filter.Match(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Send hands d to the subscribers according to the delivery mode, leaving
+// out the ones whose filter does not pick it. Random and round-robin hand
+// it to one subscriber with credit and room for it, and report it to the
+// done handler once settled. Broadcast hands it to every subscriber,
+// disconnects the ones too far behind and reports it done at once.
+// Subscribers without a connection are never handed items.
+func (s *Subscriber) Send(d Delivery) error {
+	if d.Data.Expired(time.Now()) {
+		return ErrExpired
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mode == ModeBroadcast {
+		sent := false
+		for _, addr := range append([]string(nil), s.List...) {
+			m := s.Member[addr]
+			if m.conn == nil || !m.Filter.Match(d.Data) {
+				continue
+			}
+			if err := m.conn.send(message{delivery: &d}); err != nil {
+				_, _ = s.unsubscribe(addr)
+				continue
+			}
+			sent = true
+		}
+		if !sent {
+			return ErrNoSubscriber
+		}
+		if s.done != nil {
+			go s.done(d, nil)
+		}
+		return nil
+	}
+
+	ready := make([]*Member, 0, len(s.List))
+	for _, addr := range s.List {
+		m := s.Member[addr]
+		if m.conn != nil && m.ready() && m.Filter.Match(d.Data) {
+			ready = append(ready, m)
+		}
+	}
+	if len(ready) == 0 {
+		return ErrNoSubscriber
+	}
+
+	var m *Member
+	if s.mode == ModeRoundRobin {
+		s.next %= len(ready)
+		m = ready[s.next]
+		s.next++
+	} else {
+		m = ready[rand.Intn(len(ready))]
+	}
+
+	if err := m.conn.send(message{delivery: &d, member: m}); err != nil {
+		return ErrNoSubscriber
+	}
+	m.pending++
+	if m.credit > 0 {
+		m.credit--
+	}
+	return nil
+}
+
+// Close disconnects every subscriber. Items they did not ack are left to
+// their leases, the ones not written yet are reported to the done handler.
+func (s *Subscriber) Close() {
+	s.mu.Lock()
+	conns := make([]*Conn, 0, len(s.List))
+	for _, addr := range append([]string(nil), s.List...) {
+		if c := s.Member[addr].conn; c != nil {
+			conns = append(conns, c)
+		}
+		_, _ = s.unsubscribe(addr)
+	}
+	s.mu.Unlock()
+
+	// Closing reports what the conns did not write, which takes s.mu.
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+func NewSubscriber() *Subscriber {
+	return &Subscriber{
+		Member: make(map[string]*Member),
+	}
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"container/list"
+	"time"
+)
+
+// Expired reports whether the item outlived its TTL at now. A zero now,
+// like in commands logged before items had a TTL, never expires anything.
+func (d Data) Expired(now time.Time) bool {
+	return d.ExpiresAt != nil && !now.IsZero() && !d.ExpiresAt.After(now)
+}
+
+// Stale reports whether any queued, scheduled or dead-letter item expired
+// at now.
+func (q *Queue) Stale(now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	next, ok := q.nextExpiry()
+	return ok && !now.IsZero() && !next.at.After(now)
+}
+
+// Reap drops every queued, scheduled and dead-letter item expired at now. In-flight
+// items are left to their consumer and reaped once they come back.
+func (q *Queue) Reap(now time.Time) []Data {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	reaped := make([]Data, 0)
+	dead := make(map[string]bool)
+	for {
+		next, ok := q.nextExpiry()
+		if !ok || now.IsZero() || next.at.After(now) {
+			break
+		}
+		if e, ok := q.elements[next.key]; ok {
+			reaped = append(reaped, q.remove(e))
+		} else if s, ok := q.delayedKeys[next.key]; ok {
+			q.unschedule(next.key)
+			reaped = append(reaped, s.data)
+		} else {
+			dead[next.key] = true
+			q.untrack(next.key)
+		}
+		delete(q.KeySet, next.key)
+	}
+	if len(dead) == 0 {
+		return reaped
+	}
+
+	alive := q.DeadLetter[:0]
+	for _, d := range q.DeadLetter {
+		if dead[d.Key] {
+			reaped = append(reaped, d)
+			continue
+		}
+		alive = append(alive, d)
+	}
+	q.DeadLetter = alive
+	return reaped
+}
+
+// head queues scheduled items due at now, drops expired items from head
+// of the queue and returns the first one alive, nil if there is none.
+// Callers should hold q.mu.
+func (q *Queue) head(now time.Time) *list.Element {
+	q.promote(now)
+	for e := q.front(); e != nil; e = q.front() {
+		d := e.Value.(Data)
+		if !d.Expired(now) {
+			return e
+		}
+		q.remove(e)
+		delete(q.KeySet, d.Key)
+	}
+	return nil
+}
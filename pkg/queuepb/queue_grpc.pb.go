@@ -0,0 +1,350 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: queue.proto
+
+package queuepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Queue_Push_FullMethodName      = "/sad.queue.v1.Queue/Push"
+	Queue_PushBatch_FullMethodName = "/sad.queue.v1.Queue/PushBatch"
+	Queue_Pull_FullMethodName      = "/sad.queue.v1.Queue/Pull"
+	Queue_Ack_FullMethodName       = "/sad.queue.v1.Queue/Ack"
+	Queue_Peek_FullMethodName      = "/sad.queue.v1.Queue/Peek"
+	Queue_Subscribe_FullMethodName = "/sad.queue.v1.Queue/Subscribe"
+)
+
+// QueueClient is the client API for Queue service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Queue mirrors the queue endpoints of the HTTP API. An empty queue name
+// means the default queue.
+type QueueClient interface {
+	// Push saves an item, or returns the original one if it is a retry.
+	Push(ctx context.Context, in *PushRequest, opts ...grpc.CallOption) (*Item, error)
+	// PushBatch saves items at once and reports the outcome of each one.
+	PushBatch(ctx context.Context, in *PushBatchRequest, opts ...grpc.CallOption) (*PushBatchResponse, error)
+	// Pull removes items from head of the queue, or leases them.
+	Pull(ctx context.Context, in *PullRequest, opts ...grpc.CallOption) (*PullResponse, error)
+	// Ack removes a leased item for good.
+	Ack(ctx context.Context, in *AckRequest, opts ...grpc.CallOption) (*Item, error)
+	// Peek returns head of the queue without removing it.
+	Peek(ctx context.Context, in *PeekRequest, opts ...grpc.CallOption) (*Item, error)
+	// Subscribe streams items of the queue, or of its consumer group, as
+	// they arrive. Items are acked once sent. Only the raft leader serves
+	// subscribers.
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (Queue_SubscribeClient, error)
+}
+
+type queueClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewQueueClient(cc grpc.ClientConnInterface) QueueClient {
+	return &queueClient{cc}
+}
+
+func (c *queueClient) Push(ctx context.Context, in *PushRequest, opts ...grpc.CallOption) (*Item, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Item)
+	err := c.cc.Invoke(ctx, Queue_Push_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *queueClient) PushBatch(ctx context.Context, in *PushBatchRequest, opts ...grpc.CallOption) (*PushBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PushBatchResponse)
+	err := c.cc.Invoke(ctx, Queue_PushBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *queueClient) Pull(ctx context.Context, in *PullRequest, opts ...grpc.CallOption) (*PullResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PullResponse)
+	err := c.cc.Invoke(ctx, Queue_Pull_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *queueClient) Ack(ctx context.Context, in *AckRequest, opts ...grpc.CallOption) (*Item, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Item)
+	err := c.cc.Invoke(ctx, Queue_Ack_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *queueClient) Peek(ctx context.Context, in *PeekRequest, opts ...grpc.CallOption) (*Item, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Item)
+	err := c.cc.Invoke(ctx, Queue_Peek_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *queueClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (Queue_SubscribeClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Queue_ServiceDesc.Streams[0], Queue_Subscribe_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &queueSubscribeClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Queue_SubscribeClient interface {
+	Recv() (*Delivery, error)
+	grpc.ClientStream
+}
+
+type queueSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *queueSubscribeClient) Recv() (*Delivery, error) {
+	m := new(Delivery)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// QueueServer is the server API for Queue service.
+// All implementations must embed UnimplementedQueueServer
+// for forward compatibility
+//
+// Queue mirrors the queue endpoints of the HTTP API. An empty queue name
+// means the default queue.
+type QueueServer interface {
+	// Push saves an item, or returns the original one if it is a retry.
+	Push(context.Context, *PushRequest) (*Item, error)
+	// PushBatch saves items at once and reports the outcome of each one.
+	PushBatch(context.Context, *PushBatchRequest) (*PushBatchResponse, error)
+	// Pull removes items from head of the queue, or leases them.
+	Pull(context.Context, *PullRequest) (*PullResponse, error)
+	// Ack removes a leased item for good.
+	Ack(context.Context, *AckRequest) (*Item, error)
+	// Peek returns head of the queue without removing it.
+	Peek(context.Context, *PeekRequest) (*Item, error)
+	// Subscribe streams items of the queue, or of its consumer group, as
+	// they arrive. Items are acked once sent. Only the raft leader serves
+	// subscribers.
+	Subscribe(*SubscribeRequest, Queue_SubscribeServer) error
+	mustEmbedUnimplementedQueueServer()
+}
+
+// UnimplementedQueueServer must be embedded to have forward compatible implementations.
+type UnimplementedQueueServer struct {
+}
+
+func (UnimplementedQueueServer) Push(context.Context, *PushRequest) (*Item, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Push not implemented")
+}
+func (UnimplementedQueueServer) PushBatch(context.Context, *PushBatchRequest) (*PushBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PushBatch not implemented")
+}
+func (UnimplementedQueueServer) Pull(context.Context, *PullRequest) (*PullResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Pull not implemented")
+}
+func (UnimplementedQueueServer) Ack(context.Context, *AckRequest) (*Item, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ack not implemented")
+}
+func (UnimplementedQueueServer) Peek(context.Context, *PeekRequest) (*Item, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Peek not implemented")
+}
+func (UnimplementedQueueServer) Subscribe(*SubscribeRequest, Queue_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedQueueServer) mustEmbedUnimplementedQueueServer() {}
+
+// UnsafeQueueServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to QueueServer will
+// result in compilation errors.
+type UnsafeQueueServer interface {
+	mustEmbedUnimplementedQueueServer()
+}
+
+func RegisterQueueServer(s grpc.ServiceRegistrar, srv QueueServer) {
+	s.RegisterService(&Queue_ServiceDesc, srv)
+}
+
+func _Queue_Push_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PushRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QueueServer).Push(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Queue_Push_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QueueServer).Push(ctx, req.(*PushRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Queue_PushBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PushBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QueueServer).PushBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Queue_PushBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QueueServer).PushBatch(ctx, req.(*PushBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Queue_Pull_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PullRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QueueServer).Pull(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Queue_Pull_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QueueServer).Pull(ctx, req.(*PullRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Queue_Ack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QueueServer).Ack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Queue_Ack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QueueServer).Ack(ctx, req.(*AckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Queue_Peek_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PeekRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QueueServer).Peek(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Queue_Peek_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QueueServer).Peek(ctx, req.(*PeekRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Queue_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(QueueServer).Subscribe(m, &queueSubscribeServer{ServerStream: stream})
+}
+
+type Queue_SubscribeServer interface {
+	Send(*Delivery) error
+	grpc.ServerStream
+}
+
+type queueSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *queueSubscribeServer) Send(m *Delivery) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Queue_ServiceDesc is the grpc.ServiceDesc for Queue service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Queue_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "sad.queue.v1.Queue",
+	HandlerType: (*QueueServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Push",
+			Handler:    _Queue_Push_Handler,
+		},
+		{
+			MethodName: "PushBatch",
+			Handler:    _Queue_PushBatch_Handler,
+		},
+		{
+			MethodName: "Pull",
+			Handler:    _Queue_Pull_Handler,
+		},
+		{
+			MethodName: "Ack",
+			Handler:    _Queue_Ack_Handler,
+		},
+		{
+			MethodName: "Peek",
+			Handler:    _Queue_Peek_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _Queue_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "queue.proto",
+}
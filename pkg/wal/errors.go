@@ -0,0 +1,6 @@
+package wal
+
+import "github.com/pkg/errors"
+
+var ErrInvalidSyncPolicy = errors.New("Invalid fsync policy")
+var ErrClosed = errors.New("Write-ahead log is closed")
@@ -0,0 +1,314 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	logFile      = "wal.log"
+	snapshotFile = "snapshot"
+	headerSize   = 8
+)
+
+type SyncPolicy string
+
+const (
+	// SyncAlways fsyncs the log after every appended record.
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval fsyncs the log periodically in background.
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever leaves flushing to the operating system.
+	SyncNever SyncPolicy = "never"
+)
+
+func (p SyncPolicy) IsValid() bool {
+	return p == SyncAlways || p == SyncInterval || p == SyncNever
+}
+
+// Log is an append-only file of length-prefixed, checksummed records
+// alongside an optional snapshot that holds everything compacted so far.
+type Log struct {
+	mu     sync.Mutex
+	dir    string
+	policy SyncPolicy
+	file   *os.File
+	writer *bufio.Writer
+	dirty  bool
+	closed bool
+	done   chan struct{}
+}
+
+// Open opens (or creates) the write-ahead log placed in dir.
+func Open(dir string, policy SyncPolicy, interval time.Duration) (*Log, error) {
+	if !policy.IsValid() {
+		return nil, ErrInvalidSyncPolicy
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, logFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		dir:    dir,
+		policy: policy,
+		file:   file,
+		done:   make(chan struct{}),
+	}
+
+	// Drop a torn tail left by a crash in the middle of a write, so new
+	// records are appended right after the last valid one.
+	end, err := l.validEnd()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Truncate(end); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(end, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	l.writer = bufio.NewWriter(file)
+
+	if policy == SyncInterval {
+		go l.syncLoop(interval)
+	}
+	return l, nil
+}
+
+// Load returns the last snapshot (nil if there is none) and every record
+// appended after it, in order.
+func (l *Log) Load() ([]byte, [][]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	snapshot, err := os.ReadFile(filepath.Join(l.dir, snapshotFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	records := make([][]byte, 0)
+	_, err = l.scan(func(record []byte) {
+		records = append(records, record)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return snapshot, records, nil
+}
+
+// Append writes record to the end of the log, syncing it according to the
+// configured policy.
+func (l *Log) Append(record []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+
+	if _, err := l.writer.Write(frame(record)); err != nil {
+		return err
+	}
+
+	if l.policy == SyncAlways {
+		return l.sync()
+	}
+	if l.policy == SyncNever {
+		return l.writer.Flush()
+	}
+	l.dirty = true
+	return nil
+}
+
+// Compact atomically replaces the snapshot and the whole log, keeping only
+// the given tail records after the new snapshot.
+func (l *Log) Compact(snapshot []byte, tail ...[]byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+
+	var buf bytes.Buffer
+	for _, record := range tail {
+		buf.Write(frame(record))
+	}
+
+	logPath := filepath.Join(l.dir, logFile)
+	if err := writeFileSync(logPath+".tmp", buf.Bytes()); err != nil {
+		return err
+	}
+	path := filepath.Join(l.dir, snapshotFile)
+	if err := writeFileSync(path+".tmp", snapshot); err != nil {
+		return err
+	}
+
+	// The snapshot goes first: a crash between the renames leaves the new
+	// snapshot with the old log, whose extra records replay harmlessly.
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	if err := os.Rename(logPath+".tmp", logPath); err != nil {
+		return err
+	}
+	if err := syncDir(l.dir); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(logPath, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	l.file.Close()
+	l.file = file
+	l.writer.Reset(file)
+	l.dirty = false
+	return nil
+}
+
+// Sync flushes buffered records and fsyncs the log file.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+	return l.sync()
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.done)
+
+	if err := l.sync(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+func (l *Log) sync() error {
+	if err := l.writer.Flush(); err != nil {
+		return err
+	}
+	l.dirty = false
+	return l.file.Sync()
+}
+
+func (l *Log) syncLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			if l.dirty && !l.closed {
+				_ = l.sync()
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// validEnd returns the offset right after the last intact record.
+func (l *Log) validEnd() (int64, error) {
+	return l.scan(func([]byte) {})
+}
+
+// scan reads records from the beginning of the log until EOF or the first
+// damaged record and reports where the intact part ends.
+func (l *Log) scan(fn func([]byte)) (int64, error) {
+	if l.writer != nil {
+		if err := l.writer.Flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	f, err := os.Open(l.file.Name())
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var offset int64
+	var header [headerSize]byte
+	for {
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			return offset, nil
+		}
+		size := binary.BigEndian.Uint32(header[:4])
+		sum := binary.BigEndian.Uint32(header[4:])
+
+		record := make([]byte, size)
+		if _, err := io.ReadFull(reader, record); err != nil {
+			return offset, nil
+		}
+		if crc32.ChecksumIEEE(record) != sum {
+			return offset, nil
+		}
+
+		fn(record)
+		offset += int64(headerSize) + int64(size)
+	}
+}
+
+// frame prefixes record with its length and checksum.
+func frame(record []byte) []byte {
+	b := make([]byte, headerSize+len(record))
+	binary.BigEndian.PutUint32(b[:4], uint32(len(record)))
+	binary.BigEndian.PutUint32(b[4:], crc32.ChecksumIEEE(record))
+	copy(b[headerSize:], record)
+	return b
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
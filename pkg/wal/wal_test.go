@@ -0,0 +1,88 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLogReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(dir, SyncAlways, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []string{"a", "b", "c"} {
+		if err := l.Append([]byte(r)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash in the middle of writing the next record.
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{0, 0, 0, 9, 1})
+	f.Close()
+
+	l, err = Open(dir, SyncAlways, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if err := l.Append([]byte("d")); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, records, err := l.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot != nil {
+		t.Fatalf("unexpected snapshot %q", snapshot)
+	}
+	if len(records) != 4 || string(records[3]) != "d" {
+		t.Fatalf("unexpected records %q", records)
+	}
+}
+
+func TestLogCompact(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(dir, SyncInterval, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Append([]byte("a"))
+	if err := l.Compact([]byte("snapshot"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Append([]byte("c"))
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = Open(dir, SyncNever, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	snapshot, records, err := l.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(snapshot) != "snapshot" {
+		t.Fatalf("unexpected snapshot %q", snapshot)
+	}
+	if len(records) != 2 || string(records[0]) != "b" || string(records[1]) != "c" {
+		t.Fatalf("unexpected records %q", records)
+	}
+}
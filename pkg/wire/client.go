@@ -0,0 +1,287 @@
+package wire
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// retryBackoff is the pause before the first retry, doubled on each one
+// after it.
+const retryBackoff = 10 * time.Millisecond
+
+// Client calls methods of servers over a pool of connections per address.
+// Connections are kept open and shared by concurrent calls.
+type Client struct {
+	mu      sync.Mutex
+	pools   map[string][]*conn
+	next    map[string]int
+	size    int
+	timeout time.Duration
+	retries int
+	// idempotent holds methods safe to handle twice, which are retried
+	// even if they were sent when the connection broke.
+	idempotent map[string]bool
+	closed     bool
+}
+
+// Idempotent marks methods a server may handle more than once with no
+// harm.
+func (c *Client) Idempotent(methods ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range methods {
+		c.idempotent[m] = true
+	}
+}
+
+// Call sends body to method of the server at addr and waits for the
+// reply. A request that could not be sent is retried on a fresh
+// connection, one that was sent is not unless its method is idempotent, as
+// the server may have handled it.
+func (c *Client) Call(addr string, method string, body []byte) ([]byte, error) {
+	c.mu.Lock()
+	idempotent := c.idempotent[method]
+	c.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(retryBackoff << (attempt - 1))
+		}
+
+		var cn *conn
+		cn, err = c.get(addr)
+		if err == ErrClosed {
+			return nil, err
+		}
+		if err != nil {
+			continue
+		}
+
+		var reply []byte
+		var sent bool
+		reply, sent, err = cn.call(method, body, c.timeout)
+		if err == nil || (sent && !(idempotent && err == ErrConnBroken)) {
+			return reply, err
+		}
+		c.drop(addr, cn)
+	}
+	return nil, err
+}
+
+// Close drops every connection. Calls after it fail with ErrClosed.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for _, pool := range c.pools {
+		for _, cn := range pool {
+			if cn != nil {
+				cn.close(ErrClosed)
+			}
+		}
+	}
+	c.pools = make(map[string][]*conn)
+	return nil
+}
+
+// get returns the next connection of the pool of addr in turn, dialing it
+// if it is missing or broken.
+func (c *Client) get(addr string) (*conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	pool, ok := c.pools[addr]
+	if !ok {
+		pool = make([]*conn, c.size)
+		c.pools[addr] = pool
+	}
+	i := c.next[addr]
+	c.next[addr] = (i + 1) % c.size
+	cn := pool[i]
+	c.mu.Unlock()
+
+	if cn != nil && !cn.broken() {
+		return cn, nil
+	}
+
+	nc, err := net.DialTimeout("tcp", addr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	cn = newConn(nc)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		cn.close(ErrClosed)
+		return nil, ErrClosed
+	}
+	// Another call may have dialed the same slot meanwhile.
+	if old := c.pools[addr][i]; old != nil && !old.broken() {
+		cn.close(ErrClosed)
+		return old, nil
+	}
+	c.pools[addr][i] = cn
+	return cn, nil
+}
+
+func (c *Client) drop(addr string, cn *conn) {
+	cn.close(ErrConnBroken)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, old := range c.pools[addr] {
+		if old == cn {
+			c.pools[addr][i] = nil
+		}
+	}
+}
+
+// conn is a connection to a server with the calls waiting for replies on
+// it.
+type conn struct {
+	nc net.Conn
+
+	// wmu serializes writing frames.
+	wmu sync.Mutex
+	w   *bufio.Writer
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan frame
+	err     error
+}
+
+// call sends a request and waits up to timeout for its reply. sent tells
+// whether the request reached the connection whole.
+func (cn *conn) call(method string, body []byte, timeout time.Duration) (reply []byte, sent bool, err error) {
+	cn.mu.Lock()
+	if cn.err != nil {
+		err := cn.err
+		cn.mu.Unlock()
+		return nil, false, err
+	}
+	cn.nextID++
+	id := cn.nextID
+	ch := make(chan frame, 1)
+	cn.pending[id] = ch
+	cn.mu.Unlock()
+
+	cn.wmu.Lock()
+	cn.nc.SetWriteDeadline(time.Now().Add(timeout))
+	err = writeFrame(cn.w, frame{id: id, kind: kindRequest, method: method, body: body})
+	cn.wmu.Unlock()
+	if err != nil {
+		cn.forget(id)
+		if err == ErrFrameTooLarge || err == ErrInvalidFrame {
+			// Nothing was written, and a retry would not do better.
+			return nil, true, err
+		}
+		cn.close(ErrConnBroken)
+		return nil, false, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case f, ok := <-ch:
+		if !ok {
+			return nil, true, cn.failure()
+		}
+		if f.kind == kindError {
+			return nil, true, errors.New(string(f.body))
+		}
+		return f.body, true, nil
+	case <-timer.C:
+		cn.forget(id)
+		return nil, true, ErrTimeout
+	}
+}
+
+func (cn *conn) readLoop() {
+	r := bufio.NewReader(cn.nc)
+	for {
+		f, err := readFrame(r)
+		if err != nil {
+			cn.close(ErrConnBroken)
+			return
+		}
+
+		cn.mu.Lock()
+		ch, ok := cn.pending[f.id]
+		delete(cn.pending, f.id)
+		cn.mu.Unlock()
+		if ok {
+			ch <- f
+		}
+	}
+}
+
+func (cn *conn) forget(id uint64) {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	delete(cn.pending, id)
+}
+
+func (cn *conn) broken() bool {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	return cn.err != nil
+}
+
+func (cn *conn) failure() error {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	return cn.err
+}
+
+// close fails the calls waiting on the connection with err.
+func (cn *conn) close(err error) {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+
+	if cn.err != nil {
+		return
+	}
+	cn.err = err
+	cn.nc.Close()
+	for id, ch := range cn.pending {
+		close(ch)
+		delete(cn.pending, id)
+	}
+}
+
+func newConn(nc net.Conn) *conn {
+	cn := &conn{
+		nc:      nc,
+		w:       bufio.NewWriter(nc),
+		pending: make(map[uint64]chan frame),
+	}
+	go cn.readLoop()
+	return cn
+}
+
+// NewClient returns a client keeping size connections per address, which
+// waits timeout for a reply and retries a request that could not be sent
+// up to retries times.
+func NewClient(size int, timeout time.Duration, retries int) *Client {
+	if size < 1 {
+		size = 1
+	}
+	return &Client{
+		pools:      make(map[string][]*conn),
+		next:       make(map[string]int),
+		size:       size,
+		timeout:    timeout,
+		retries:    retries,
+		idempotent: make(map[string]bool),
+	}
+}
@@ -0,0 +1,10 @@
+package wire
+
+import "github.com/pkg/errors"
+
+var ErrFrameTooLarge = errors.New("Frame is larger than the limit")
+var ErrInvalidFrame = errors.New("Frame is malformed")
+var ErrUnknownMethod = errors.New("Method is not registered")
+var ErrConnBroken = errors.New("Connection to peer is broken")
+var ErrTimeout = errors.New("Timed out while waiting for the reply")
+var ErrClosed = errors.New("Connection is closed")
@@ -0,0 +1,90 @@
+package wire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	// MaxFrame bounds a single frame, so a corrupt length does not make a
+	// peer allocate whatever it says. Snapshots travel in smaller chunks.
+	MaxFrame = 256 << 20
+
+	// headerSize covers request ID, kind and method length.
+	headerSize = 8 + 1 + 1
+)
+
+type kind byte
+
+const (
+	kindRequest kind = iota + 1
+	kindReply
+	kindError
+)
+
+// frame is a request, or the reply to the request with the same ID. On
+// the wire it is its length as a big endian uint32 followed by the ID,
+// the kind, the length of the method, the method and the body.
+type frame struct {
+	id     uint64
+	kind   kind
+	method string
+	body   []byte
+}
+
+func writeFrame(w *bufio.Writer, f frame) error {
+	if len(f.method) > 255 {
+		return ErrInvalidFrame
+	}
+	size := headerSize + len(f.method) + len(f.body)
+	if size > MaxFrame {
+		return ErrFrameTooLarge
+	}
+
+	var header [4 + headerSize]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(size))
+	binary.BigEndian.PutUint64(header[4:12], f.id)
+	header[12] = byte(f.kind)
+	header[13] = byte(len(f.method))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := w.WriteString(f.method); err != nil {
+		return err
+	}
+	if _, err := w.Write(f.body); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func readFrame(r *bufio.Reader) (frame, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return frame{}, err
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size < headerSize {
+		return frame{}, ErrInvalidFrame
+	}
+	if size > MaxFrame {
+		return frame{}, ErrFrameTooLarge
+	}
+
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return frame{}, err
+	}
+	f := frame{
+		id:   binary.BigEndian.Uint64(b[0:8]),
+		kind: kind(b[8]),
+	}
+	end := headerSize + int(b[9])
+	if end > len(b) || f.kind < kindRequest || f.kind > kindError {
+		return frame{}, ErrInvalidFrame
+	}
+	f.method = string(b[headerSize:end])
+	f.body = b[end:]
+	return f, nil
+}
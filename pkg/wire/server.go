@@ -0,0 +1,140 @@
+package wire
+
+import (
+	"bufio"
+	"net"
+	"sync"
+)
+
+// Handler answers the body of a request. Its error reaches the caller as
+// the error of Call.
+type Handler func(body []byte) ([]byte, error)
+
+// Server answers framed requests on long-lived connections. Requests of a
+// connection are handled concurrently and replies go back as they are
+// ready, matched to their request by ID.
+type Server struct {
+	mu        sync.Mutex
+	handlers  map[string]Handler
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+// Handle registers h for method. Requests for a method with no handler
+// get ErrUnknownMethod.
+func (s *Server) Handle(method string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[method] = h
+}
+
+// Serve accepts connections on ln until it fails or the server is closed.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrClosed
+	}
+	s.listeners[ln] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, ln)
+			s.mu.Unlock()
+			if closed {
+				return ErrClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return ErrClosed
+		}
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		go s.serveConn(c)
+	}
+}
+
+// Close stops the listeners and drops every connection.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return nil
+}
+
+func (s *Server) serveConn(c net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	var wmu sync.Mutex
+	for {
+		f, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		if f.kind != kindRequest {
+			continue
+		}
+
+		go func(f frame) {
+			reply := s.handle(f)
+			wmu.Lock()
+			defer wmu.Unlock()
+			err := writeFrame(w, reply)
+			if err == ErrFrameTooLarge {
+				err = writeFrame(w, frame{id: f.id, kind: kindError, body: []byte(err.Error())})
+			}
+			if err != nil {
+				c.Close()
+			}
+		}(f)
+	}
+}
+
+func (s *Server) handle(f frame) frame {
+	s.mu.Lock()
+	h, ok := s.handlers[f.method]
+	s.mu.Unlock()
+
+	if !ok {
+		return frame{id: f.id, kind: kindError, body: []byte(ErrUnknownMethod.Error())}
+	}
+	body, err := h(f.body)
+	if err != nil {
+		return frame{id: f.id, kind: kindError, body: []byte(err.Error())}
+	}
+	return frame{id: f.id, kind: kindReply, body: body}
+}
+
+func NewServer() *Server {
+	return &Server{
+		handlers:  make(map[string]Handler),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
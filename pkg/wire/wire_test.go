@@ -0,0 +1,210 @@
+package wire
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func listen(t testing.TB, s *Server) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+	return ln.Addr().String()
+}
+
+func echoServer() *Server {
+	s := NewServer()
+	s.Handle("echo", func(body []byte) ([]byte, error) {
+		return body, nil
+	})
+	s.Handle("fail", func(body []byte) ([]byte, error) {
+		return nil, errors.New("failed: " + string(body))
+	})
+	s.Handle("slow", func(body []byte) ([]byte, error) {
+		time.Sleep(100 * time.Millisecond)
+		return body, nil
+	})
+	return s
+}
+
+func TestCall(t *testing.T) {
+	addr := listen(t, echoServer())
+	c := NewClient(2, time.Second, 2)
+	defer c.Close()
+
+	t.Run("echo", func(t *testing.T) {
+		// Keys and values are sent as they are, whatever they hold.
+		body := []byte("key=a&b c\n\x00/?#")
+		reply, err := c.Call(addr, "echo", body)
+		if err != nil || !bytes.Equal(reply, body) {
+			t.Fatalf("expected %q, got %q, %v", body, reply, err)
+		}
+	})
+
+	t.Run("remote error", func(t *testing.T) {
+		_, err := c.Call(addr, "fail", []byte("x"))
+		if err == nil || err.Error() != "failed: x" {
+			t.Fatalf("expected remote error, got %v", err)
+		}
+	})
+
+	t.Run("unknown method", func(t *testing.T) {
+		_, err := c.Call(addr, "missing", nil)
+		if err == nil || err.Error() != ErrUnknownMethod.Error() {
+			t.Fatalf("expected unknown method, got %v", err)
+		}
+	})
+
+	t.Run("concurrent calls", func(t *testing.T) {
+		var wg sync.WaitGroup
+		errs := make(chan error, 100)
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				body := []byte(fmt.Sprintf("call %d", i))
+				reply, err := c.Call(addr, "echo", body)
+				if err == nil && !bytes.Equal(reply, body) {
+					err = fmt.Errorf("call %d got reply %q", i, reply)
+				}
+				errs <- err
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	t.Run("replies out of order", func(t *testing.T) {
+		done := make(chan error, 1)
+		go func() {
+			_, err := c.Call(addr, "slow", []byte("slow"))
+			done <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+		if _, err := c.Call(addr, "echo", []byte("fast")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("slow call ended before the fast one with %v", err)
+		default:
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestTimeout(t *testing.T) {
+	addr := listen(t, echoServer())
+	c := NewClient(1, 20*time.Millisecond, 2)
+	defer c.Close()
+
+	if _, err := c.Call(addr, "slow", nil); err != ErrTimeout {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	// A late reply does not confuse the next call.
+	time.Sleep(100 * time.Millisecond)
+	if reply, err := c.Call(addr, "echo", []byte("a")); err != nil || string(reply) != "a" {
+		t.Fatalf("expected a, got %q, %v", reply, err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	s := echoServer()
+	go s.Serve(ln)
+
+	c := NewClient(1, time.Second, 3)
+	c.Idempotent("echo")
+	defer c.Close()
+	if _, err := c.Call(addr, "echo", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pooled connection breaks with the server, the next call dials a
+	// new one once the server is back. The call may go out on the broken
+	// connection first, which is fine to retry as echo is idempotent.
+	s.Close()
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = echoServer()
+	go s.Serve(ln)
+	defer s.Close()
+
+	if reply, err := c.Call(addr, "echo", []byte("b")); err != nil || string(reply) != "b" {
+		t.Fatalf("expected b, got %q, %v", reply, err)
+	}
+}
+
+func TestInvalidFrame(t *testing.T) {
+	addr := listen(t, echoServer())
+	c := NewClient(1, time.Second, 0)
+	defer c.Close()
+
+	if _, err := c.Call(addr, strings.Repeat("m", 256), nil); err != ErrInvalidFrame {
+		t.Fatalf("expected invalid frame, got %v", err)
+	}
+	if _, err := c.Call(addr, "echo", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var payload = bytes.Repeat([]byte("x"), 512)
+
+func BenchmarkCall(b *testing.B) {
+	addr := listen(b, echoServer())
+	c := NewClient(2, time.Second, 2)
+	defer c.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Call(addr, "echo", payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkHTTP posts like raft messages were carried before, to compare
+// with BenchmarkCall.
+func BenchmarkHTTP(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	defer srv.Close()
+	client := &http.Client{Timeout: time.Second}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := client.Post(srv.URL+"/_raft/echo", "application/json", bytes.NewReader(payload))
+		if err != nil {
+			b.Fatal(err)
+		}
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+}